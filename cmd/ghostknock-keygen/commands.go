@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/keyfile"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// subcommands asocia cada subcomando de ghostknock-keygen con su función. Sin
+// subcomando, ghostknock-keygen genera un par de claves nuevo.
+var subcommands = map[string]func(defaultPath string, args []string){
+	"list":        runList,
+	"fingerprint": runFingerprint,
+	"pubkey":      runPubkey,
+	"verify":      runVerify,
+	"user":        runUser,
+	"sign-cert":   runSignCert,
+}
+
+// defaultCertValidity es la vigencia por defecto de los certificados: los
+// certificados cortos evitan tener que revocarlos.
+const defaultCertValidity = 8 * time.Hour
+
+// userStanza es la entrada de la sección 'users' que imprime el subcomando user.
+type userStanza struct {
+	Name      string   `yaml:"name"`
+	PublicKey string   `yaml:"public_key"`
+	SourceIPs []string `yaml:"source_ips,omitempty"`
+	Actions   []string `yaml:"actions"`
+}
+
+// runList muestra la huella de cada clave pública (*.pub) de un directorio.
+func runList(defaultPath string, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dir := fs.String("dir", filepath.Dir(defaultPath), "Directorio en el que buscar claves públicas (*.pub)")
+	fs.Parse(args)
+
+	paths, err := filepath.Glob(filepath.Join(*dir, "*.pub"))
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo listar '%s': %v", *dir, err)
+	}
+	if len(paths) == 0 {
+		log.Printf("No hay claves públicas en '%s'.", *dir)
+		return
+	}
+	for _, path := range paths {
+		publicKey, err := readPublicKeyFile(path)
+		if err != nil {
+			log.Printf("Aviso: se omite '%s': %v", path, err)
+			continue
+		}
+		fmt.Printf("%s  %s  %s\n", sshFingerprint(publicKey), keyID(publicKey), path)
+	}
+}
+
+// runFingerprint imprime la huella SHA256 (la misma que muestra ssh-add -l) y
+// el identificador corto que aparece como 'key_id' en los logs del demonio.
+func runFingerprint(defaultPath string, args []string) {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	keyPath := fs.String("key", defaultPath, "Clave privada, o su archivo .pub")
+	passphraseFD := registerPassphraseFD(fs)
+	fs.Parse(args)
+
+	publicKey := loadPublicKey(*keyPath, *passphraseFD)
+	fmt.Printf("Huella:     %s\n", sshFingerprint(publicKey))
+	fmt.Printf("Key ID:     %s\n", keyID(publicKey))
+}
+
+// runPubkey imprime la clave pública en Base64 o en formato de OpenSSH.
+func runPubkey(defaultPath string, args []string) {
+	fs := flag.NewFlagSet("pubkey", flag.ExitOnError)
+	keyPath := fs.String("key", defaultPath, "Clave privada, o su archivo .pub")
+	sshFormat := fs.Bool("ssh", false, "Imprime la clave como línea 'ssh-ed25519 AAAA...' en lugar de Base64")
+	passphraseFD := registerPassphraseFD(fs)
+	fs.Parse(args)
+
+	publicKey := loadPublicKey(*keyPath, *passphraseFD)
+	if *sshFormat {
+		fmt.Println(sshPublicKeyLine(publicKey))
+		return
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(publicKey))
+}
+
+// runVerify comprueba que una clave privada corresponde a la clave pública de
+// config.yaml. Sale con código 1 si no coinciden.
+func runVerify(defaultPath string, args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPath := fs.String("key", defaultPath, "Clave privada a comprobar")
+	expected := fs.String("pubkey", "", "Clave pública esperada, tal y como aparece en 'public_key' (Base64 o ssh-ed25519) (requerido)")
+	passphraseFD := registerPassphraseFD(fs)
+	fs.Parse(args)
+
+	if *expected == "" {
+		log.Fatalf("FATAL: El flag -pubkey es requerido.")
+	}
+	expectedKey, err := config.ParsePublicKey(*expected)
+	if err != nil {
+		log.Fatalf("FATAL: -pubkey no es una clave pública válida: %v", err)
+	}
+
+	privateKey := loadPrivateKey(*keyPath, *passphraseFD)
+	if !bytes.Equal(privateKey.Public().(ed25519.PublicKey), expectedKey) {
+		fmt.Printf("NO COINCIDE: '%s' (%s) no corresponde a la clave pública indicada (%s).\n",
+			*keyPath, sshFingerprint(privateKey.Public().(ed25519.PublicKey)), sshFingerprint(expectedKey))
+		os.Exit(1)
+	}
+	fmt.Printf("OK: '%s' corresponde a la clave pública indicada (%s).\n", *keyPath, sshFingerprint(expectedKey))
+}
+
+// runUser imprime una entrada lista para pegar en la sección 'users' de config.yaml.
+func runUser(defaultPath string, args []string) {
+	fs := flag.NewFlagSet("user", flag.ExitOnError)
+	keyPath := fs.String("key", defaultPath, "Clave privada, o su archivo .pub")
+	name := fs.String("name", "", "Nombre del usuario (requerido)")
+	actions := fs.String("actions", "", "Acciones permitidas, separadas por comas (requerido)")
+	sourceIPs := fs.String("source-ips", "", "IPs/CIDRs permitidos, separados por comas (opcional)")
+	passphraseFD := registerPassphraseFD(fs)
+	fs.Parse(args)
+
+	if *name == "" || *actions == "" {
+		log.Fatalf("FATAL: Los flags -name y -actions son requeridos.")
+	}
+
+	stanza := userStanza{
+		Name:      *name,
+		PublicKey: base64.StdEncoding.EncodeToString(loadPublicKey(*keyPath, *passphraseFD)),
+		SourceIPs: splitList(*sourceIPs),
+		Actions:   splitList(*actions),
+	}
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string][]userStanza{"users": {stanza}}); err != nil {
+		log.Fatalf("FATAL: No se pudo generar el YAML: %v", err)
+	}
+	encoder.Close()
+}
+
+// runSignCert emite, con la clave de la CA, un certificado para la clave pública
+// de un miembro del equipo. La firma se hace sin conexión con el servidor.
+func runSignCert(defaultPath string, args []string) {
+	fs := flag.NewFlagSet("sign-cert", flag.ExitOnError)
+	caPath := fs.String("ca", "", "Clave privada de la CA (requerido)")
+	subjectKey := fs.String("pubkey", "", "Clave pública del titular: archivo .pub o valor en Base64/ssh-ed25519 (requerido)")
+	subject := fs.String("subject", "", "Titular del certificado, tal y como aparecerá en los logs (requerido)")
+	actions := fs.String("actions", "", "Acciones permitidas, separadas por comas (requerido)")
+	sourceIPs := fs.String("source-ips", "", "IPs/CIDRs permitidos, separados por comas (opcional)")
+	validity := fs.Duration("validity", defaultCertValidity, "Vigencia del certificado desde este momento")
+	outputFile := fs.String("o", "", "Archivo del certificado (por defecto, junto al .pub con el sufijo -cert.pem)")
+	passphraseFD := registerPassphraseFD(fs)
+	fs.Parse(args)
+
+	if *caPath == "" || *subjectKey == "" || *subject == "" || *actions == "" {
+		log.Fatalf("FATAL: Los flags -ca, -pubkey, -subject y -actions son requeridos.")
+	}
+	if *validity <= 0 {
+		log.Fatalf("FATAL: -validity debe ser positivo.")
+	}
+	for _, ipStr := range splitList(*sourceIPs) {
+		if _, _, err := net.ParseCIDR(ipStr); err != nil {
+			log.Fatalf("FATAL: '%s' no es un CIDR válido (ej. '1.2.3.4/32'): %v", ipStr, err)
+		}
+	}
+
+	var publicKey ed25519.PublicKey
+	var err error
+	if fileExists(*subjectKey) {
+		publicKey, err = readPublicKeyFile(*subjectKey)
+	} else {
+		publicKey, err = config.ParsePublicKey(*subjectKey)
+	}
+	if err != nil {
+		log.Fatalf("FATAL: -pubkey no es una clave pública válida: %v", err)
+	}
+
+	certFile := *outputFile
+	if certFile == "" {
+		if !strings.HasSuffix(*subjectKey, ".pub") || !fileExists(*subjectKey) {
+			log.Fatalf("FATAL: Indique el archivo del certificado con -o.")
+		}
+		certFile = strings.TrimSuffix(*subjectKey, ".pub") + "-cert.pem"
+	}
+
+	caKey := loadPrivateKey(*caPath, *passphraseFD)
+	cert := protocol.NewCertificate(*subject, publicKey, splitList(*actions), splitList(*sourceIPs), time.Now(), *validity)
+	certBytes, err := cert.Sign(caKey)
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo firmar el certificado: %v", err)
+	}
+	if err := os.WriteFile(certFile, protocol.EncodeCertificatePEM(certBytes), publicKeyPerms); err != nil {
+		log.Fatalf("FATAL: No se pudo guardar el certificado en '%s': %v", certFile, err)
+	}
+
+	log.Printf("Certificado guardado en: %s", certFile)
+	fmt.Printf("Titular:    %s\n", cert.Subject)
+	fmt.Printf("Serie:      %s\n", cert.Serial)
+	fmt.Printf("Clave:      %s\n", sshFingerprint(publicKey))
+	fmt.Printf("CA:         %s\n", sshFingerprint(caKey.Public().(ed25519.PublicKey)))
+	fmt.Printf("Acciones:   %s\n", strings.Join(cert.Actions, ", "))
+	if len(cert.SourceIPs) > 0 {
+		fmt.Printf("Orígenes:   %s\n", strings.Join(cert.SourceIPs, ", "))
+	}
+	fmt.Printf("Válido:     %s - %s\n", cert.ValidFrom().Format(time.RFC3339), cert.ValidUntil().Format(time.RFC3339))
+}
+
+// registerPassphraseFD declara el flag -passphrase-fd, igual que en ghostknock.
+func registerPassphraseFD(fs *flag.FlagSet) *int {
+	return fs.Int("passphrase-fd", -1, "Descriptor de archivo del que leer la frase de paso de una clave cifrada (alternativa a "+keyfile.PassphraseEnv+")")
+}
+
+// loadPrivateKey carga una clave privada en cualquiera de los formatos del cliente.
+func loadPrivateKey(path string, passphraseFD int) ed25519.PrivateKey {
+	privateKey, err := keyfile.Load(path, func() ([]byte, error) {
+		return keyfile.ReadPassphrase(passphraseFD, fmt.Sprintf("Frase de paso para '%s': ", path))
+	})
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo cargar la clave privada '%s': %v", path, err)
+	}
+	return privateKey
+}
+
+// loadPublicKey obtiene la clave pública de un archivo .pub o, si no lo es, de
+// la clave privada (lo que puede requerir la frase de paso).
+func loadPublicKey(path string, passphraseFD int) ed25519.PublicKey {
+	if strings.HasSuffix(path, ".pub") {
+		publicKey, err := readPublicKeyFile(path)
+		if err != nil {
+			log.Fatalf("FATAL: No se pudo leer la clave pública '%s': %v", path, err)
+		}
+		return publicKey
+	}
+	return loadPrivateKey(path, passphraseFD).Public().(ed25519.PublicKey)
+}
+
+// readPublicKeyFile lee un .pub de ghostknock-keygen (32 bytes en bruto) o de
+// ssh-keygen (línea ssh-ed25519).
+func readPublicKeyFile(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(data), nil
+	}
+	return config.ParsePublicKey(string(data))
+}
+
+// sshPublicKeyLine devuelve la clave en el formato de authorized_keys.
+func sshPublicKeyLine(publicKey ed25519.PublicKey) string {
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo convertir la clave al formato de OpenSSH: %v", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey)))
+}
+
+// sshFingerprint devuelve la huella SHA256 de la clave al estilo de OpenSSH.
+func sshFingerprint(publicKey ed25519.PublicKey) string {
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "(clave inválida)"
+	}
+	return ssh.FingerprintSHA256(sshKey)
+}
+
+// keyID devuelve el identificador corto de la clave en hexadecimal.
+func keyID(publicKey ed25519.PublicKey) string {
+	id := protocol.KeyIDFromPublicKey(publicKey)
+	return hex.EncodeToString(id[:])
+}
+
+// splitList separa una lista separada por comas, descartando los elementos vacíos.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
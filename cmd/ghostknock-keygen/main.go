@@ -3,14 +3,19 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdh"
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/your-org/ghostknock/internal/keyfile"
 )
 
 const (
@@ -18,6 +23,8 @@ const (
 	publicKeyPerms  = 0644
 	configDirPerms  = 0700 // Solo el propietario puede acceder a este directorio.
 	defaultKeyFile  = "id_ed25519"
+	// defaultSealingKeyFile es la ruta habitual de la clave de sellado en el servidor.
+	defaultSealingKeyFile = "/etc/ghostknock/sealing_x25519"
 )
 
 // fileExists comprueba si un archivo existe en la ruta dada.
@@ -37,11 +44,40 @@ func main() {
 	}
 	defaultPath := filepath.Join(homeDir, ".config", "ghostknock", defaultKeyFile)
 
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(defaultPath, os.Args[2:])
+			return
+		}
+	}
+
 	// 2. AÑADIMOS UN FLAG PARA EL ARCHIVO DE SALIDA CON UN NUEVO VALOR POR DEFECTO
 	// El texto de ayuda ahora muestra la ruta por defecto, haciéndola más clara.
 	outputFile := flag.String("o", defaultPath, "Ruta base para guardar el par de claves (ej. ~/.ssh/ghostknock_admin)")
+	encrypt := flag.Bool("encrypt", false, "Cifra la clave privada con una frase de paso (Argon2id + XChaCha20-Poly1305); se lee de "+keyfile.PassphraseEnv+" o se pregunta")
+	sealing := flag.Bool("sealing", false, "Genera la clave X25519 de sellado del servidor en lugar de una identidad ed25519 (por defecto en "+defaultSealingKeyFile+")")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Uso: %[1]s [flags]                   Genera un par de claves nuevo
+     %[1]s list [-dir DIR]           Lista las claves públicas y sus huellas
+     %[1]s fingerprint [-key K]      Muestra la huella y el key ID de una clave
+     %[1]s pubkey [-key K] [-ssh]    Muestra la clave pública de una clave existente
+     %[1]s verify -pubkey P          Comprueba que la clave privada corresponde a P
+     %[1]s user -name N -actions A   Genera la entrada 'users' de config.yaml
+     %[1]s sign-cert -ca CA ...      Emite un certificado firmado por la CA del equipo
+`, os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	if *sealing && *encrypt {
+		log.Fatalf("FATAL: -encrypt no está disponible para la clave de sellado: el demonio debe poder leerla sin intervención.")
+	}
+
+	// Si se pide una clave de sellado sin ruta explícita, no usamos la ruta de la identidad del cliente.
+	if *sealing && !isFlagSet("o") {
+		*outputFile = defaultSealingKeyFile
+	}
+
 	privateKeyFile := *outputFile
 	publicKeyFile := privateKeyFile + ".pub"
 
@@ -54,8 +90,6 @@ func main() {
 		)
 	}
 
-	log.Printf("Generando un nuevo par de claves ed25519...")
-
 	// 4. CREAR EL DIRECTORIO DE CONFIGURACIÓN SI NO EXISTE
 	// os.MkdirAll es perfecto para esto: no hace nada si el directorio ya existe.
 	keyDir := filepath.Dir(privateKeyFile)
@@ -63,12 +97,64 @@ func main() {
 		log.Fatalf("FATAL: No se pudo crear el directorio de configuración en '%s': %v", keyDir, err)
 	}
 
+	if *sealing {
+		generateSealingKey(privateKeyFile, publicKeyFile)
+		return
+	}
+
+	log.Printf("Generando un nuevo par de claves ed25519...")
+
 	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		log.Fatalf("Error fatal al generar el par de claves: %v", err)
 	}
 
-	err = os.WriteFile(privateKeyFile, privateKey, privateKeyPerms)
+	privateKeyData := []byte(privateKey)
+	if *encrypt {
+		passphrase, err := newPassphrase()
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		privateKeyData, err = keyfile.Encrypt(privateKey, passphrase)
+		if err != nil {
+			log.Fatalf("FATAL: No se pudo cifrar la clave privada: %v", err)
+		}
+		log.Printf("Clave privada cifrada con la frase de paso.")
+	}
+
+	writeKeyPair(privateKeyFile, privateKeyData, publicKeyFile, publicKey)
+
+	publicKeyB64 := base64.StdEncoding.EncodeToString(publicKey)
+
+	fmt.Println("\n---")
+	fmt.Println("¡Claves generadas con éxito!")
+	fmt.Println("Añada la siguiente clave pública a la sección 'users' de su archivo config.yaml en el servidor:")
+	fmt.Printf("\n%s\n\n", publicKeyB64)
+}
+
+// generateSealingKey crea el par X25519 con el que los clientes cifran sus payloads.
+func generateSealingKey(privateKeyFile, publicKeyFile string) {
+	log.Printf("Generando un nuevo par de claves de sellado X25519...")
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("Error fatal al generar la clave de sellado: %v", err)
+	}
+	publicKey := privateKey.PublicKey().Bytes()
+
+	writeKeyPair(privateKeyFile, privateKey.Bytes(), publicKeyFile, publicKey)
+
+	fmt.Println("\n---")
+	fmt.Println("¡Clave de sellado generada con éxito!")
+	fmt.Println("En el servidor, configure en config.yaml:")
+	fmt.Printf("\nserver:\n  sealing_key_file: \"%s\"\n\n", privateKeyFile)
+	fmt.Println("En los clientes, use la siguiente clave pública con el flag -seal-key:")
+	fmt.Printf("\n%s\n\n", base64.StdEncoding.EncodeToString(publicKey))
+}
+
+// writeKeyPair guarda ambas mitades de un par de claves con sus permisos correspondientes.
+func writeKeyPair(privateKeyFile string, privateKey []byte, publicKeyFile string, publicKey []byte) {
+	err := os.WriteFile(privateKeyFile, privateKey, privateKeyPerms)
 	if err != nil {
 		log.Fatalf("Error al guardar la clave privada en '%s': %v", privateKeyFile, err)
 	}
@@ -81,11 +167,42 @@ func main() {
 		log.Fatalf("Error al guardar la clave pública en '%s': %v", publicKeyFile, err)
 	}
 	log.Printf("Clave pública guardada en: %s", publicKeyFile)
+}
 
-	publicKeyB64 := base64.StdEncoding.EncodeToString(publicKey)
+// newPassphrase obtiene la frase de paso para cifrar una clave nueva: de la
+// variable de entorno o preguntándola dos veces en la terminal.
+func newPassphrase() ([]byte, error) {
+	if pass, ok := os.LookupEnv(keyfile.PassphraseEnv); ok {
+		if pass == "" {
+			return nil, fmt.Errorf("la variable %s está vacía", keyfile.PassphraseEnv)
+		}
+		return []byte(pass), nil
+	}
 
-	fmt.Println("\n---")
-	fmt.Println("¡Claves generadas con éxito!")
-	fmt.Println("Añada la siguiente clave pública a la sección 'users' de su archivo config.yaml en el servidor:")
-	fmt.Printf("\n%s\n\n", publicKeyB64)
+	pass, err := keyfile.PromptPassphrase("Frase de paso: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("la frase de paso no puede estar vacía")
+	}
+	confirm, err := keyfile.PromptPassphrase("Repita la frase de paso: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, confirm) {
+		return nil, errors.New("las frases de paso no coinciden")
+	}
+	return pass, nil
+}
+
+// isFlagSet indica si el flag con el nombre dado se pasó explícitamente en la línea de comandos.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
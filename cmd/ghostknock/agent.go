@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSigner firma los knocks con una clave ed25519 guardada en ssh-agent, de
+// modo que la clave privada nunca se lee del disco. Implementa crypto.Signer.
+type agentSigner struct {
+	conn      net.Conn
+	client    agent.ExtendedAgent
+	key       *agent.Key
+	publicKey ed25519.PublicKey
+}
+
+// connectAgent se conecta al agente de SSH_AUTH_SOCK y selecciona la clave
+// ed25519 cuya huella (SHA256:...) o comentario coincide con selector. Si
+// selector está vacío, el agente debe tener una única clave ed25519.
+func connectAgent(selector string) (*agentSigner, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("SSH_AUTH_SOCK no está definida; ¿se está ejecutando ssh-agent?")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo conectar con el agente en '%s': %w", socket, err)
+	}
+
+	signer, err := selectAgentKey(agent.NewClient(conn), selector)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	signer.conn = conn
+	return signer, nil
+}
+
+// selectAgentKey busca en el agente la clave indicada por selector.
+func selectAgentKey(client agent.ExtendedAgent, selector string) (*agentSigner, error) {
+	keys, err := client.List()
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron listar las claves del agente: %w", err)
+	}
+
+	var candidates []*agent.Key
+	for _, key := range keys {
+		if key.Format != ssh.KeyAlgoED25519 {
+			continue
+		}
+		if selector == "" || selector == ssh.FingerprintSHA256(key) || selector == key.Comment {
+			candidates = append(candidates, key)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if selector == "" {
+			return nil, errors.New("el agente no tiene ninguna clave ed25519")
+		}
+		return nil, fmt.Errorf("el agente no tiene ninguna clave ed25519 con la huella o el comentario '%s'", selector)
+	case 1:
+	default:
+		descriptions := make([]string, 0, len(candidates))
+		for _, key := range candidates {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", ssh.FingerprintSHA256(key), key.Comment))
+		}
+		return nil, fmt.Errorf("el agente tiene varias claves ed25519 que coinciden; elija una con -agent-key: %s", strings.Join(descriptions, ", "))
+	}
+
+	key := candidates[0]
+	parsed, err := ssh.ParsePublicKey(key.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("el agente devolvió una clave inválida: %w", err)
+	}
+	publicKey, ok := parsed.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("el agente devolvió una clave que no es ed25519")
+	}
+	return &agentSigner{client: client, key: key, publicKey: publicKey}, nil
+}
+
+// Public devuelve la clave pública ed25519 seleccionada.
+func (s *agentSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign pide al agente que firme el mensaje. Ed25519 firma el mensaje completo,
+// así que la firma del agente es directamente la que espera el sobre.
+func (s *agentSigner) Sign(_ io.Reader, message []byte, _ crypto.SignerOpts) ([]byte, error) {
+	signature, err := s.client.Sign(s.key, message)
+	if err != nil {
+		return nil, fmt.Errorf("el agente rechazó la firma: %w", err)
+	}
+	if signature.Format != ssh.KeyAlgoED25519 || len(signature.Blob) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("el agente devolvió una firma inesperada (%s)", signature.Format)
+	}
+	return signature.Blob, nil
+}
+
+// Description identifica la clave en los mensajes del cliente.
+func (s *agentSigner) Description() string {
+	return fmt.Sprintf("%s (%s)", ssh.FingerprintSHA256(s.key), s.key.Comment)
+}
+
+// Close cierra la conexión con el agente.
+func (s *agentSigner) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
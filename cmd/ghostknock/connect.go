@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 20 * time.Second
+	connectProbeTimeout   = 2 * time.Second
+	connectRetryInterval  = 250 * time.Millisecond
+)
+
+// runConnect implementa 'ghostknock connect': envía el knock, espera a que el
+// puerto TCP de destino acepte conexiones y, después, ejecuta el comando dado
+// tras '--' o actúa como proxy entre stdio y la conexión. Sin comando puede
+// usarse directamente como ProxyCommand de OpenSSH:
+//
+//	ProxyCommand ghostknock connect prod -target-host %h -target-port %p
+//
+// En este modo stdout transporta la conexión, así que todos los mensajes van a stderr.
+func runConnect(args []string) {
+	log.SetFlags(0)
+	log.SetOutput(os.Stderr)
+
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	opts := &knockOptions{outputWriter: os.Stderr}
+	opts.registerFlags(fs)
+	targetHost := fs.String("target-host", "", "Host TCP de destino (por defecto, el host del knock)")
+	targetPort := fs.Int("target-port", 22, "Puerto TCP de destino")
+	timeout := fs.Duration("connect-timeout", defaultConnectTimeout, "Tiempo máximo de espera hasta que el puerto de destino acepte conexiones")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Uso: %s connect [perfil [acción]] [flags] [-- comando...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var command []string
+	for i, arg := range args {
+		if arg == "--" {
+			command = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	positional := parseInterspersed(fs, args)
+	if err := opts.resolve(fs, positional); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if code := sendKnock(opts); code != 0 {
+		os.Exit(code)
+	}
+
+	host := *targetHost
+	if host == "" {
+		host = opts.Host
+	}
+	targetAddr := net.JoinHostPort(host, strconv.Itoa(*targetPort))
+	conn, err := waitForPort(targetAddr, *timeout)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	if len(command) > 0 {
+		conn.Close()
+		log.Printf("-- %s accesible; ejecutando %s.", targetAddr, command[0])
+		os.Exit(execCommand(command))
+	}
+
+	log.Printf("-- %s accesible; conectando stdio.", targetAddr)
+	proxyStdio(conn)
+}
+
+// waitForPort reintenta la conexión TCP hasta que el destino la acepta o se
+// agota el tiempo. Devuelve la conexión abierta.
+func waitForPort(addr string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, connectProbeTimeout)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().Add(connectRetryInterval).After(deadline) {
+			return nil, fmt.Errorf("%s no aceptó conexiones en %s: %w", addr, timeout, err)
+		}
+		time.Sleep(connectRetryInterval)
+	}
+}
+
+// proxyStdio copia stdin hacia la conexión y la conexión hacia stdout hasta
+// que el extremo remoto la cierra.
+func proxyStdio(conn net.Conn) {
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		// Propagar el fin de stdin sin cerrar el sentido de lectura.
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+	}()
+
+	if _, err := io.Copy(os.Stdout, conn); err != nil {
+		log.Fatalf("FATAL: Error en la conexión: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// execCommand sustituye el proceso actual por el comando, de modo que este
+// hereda directamente la terminal y las señales. Solo regresa si falla.
+func execCommand(command []string) int {
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		log.Printf("Error: no se encontró el comando '%s': %v", command[0], err)
+		return 127
+	}
+	err = syscall.Exec(path, command, os.Environ())
+	log.Printf("Error: no se pudo ejecutar '%s': %v", command[0], err)
+	return 126
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// execCommand ejecuta el comando con la consola heredada y devuelve su código
+// de salida. Windows no permite sustituir el proceso actual como execve.
+func execCommand(command []string) int {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		log.Printf("Error: no se pudo ejecutar '%s': %v", command[0], err)
+		return 127
+	}
+	return 0
+}
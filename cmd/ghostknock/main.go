@@ -2,46 +2,191 @@
 package main
 
 import (
+	"crypto"
+	"crypto/ecdh"
 	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings" // <<-- NUEVA IMPORTACIÓN
+	"time"
 
 	// Esta ruta DEBE COINCIDIR con la línea 'module' en tu archivo go.mod
+	"github.com/your-org/ghostknock/internal/keyfile"
 	"github.com/your-org/ghostknock/internal/protocol"
 )
 
 const (
-	defaultKeyFile = "id_ed25519"
+	defaultKeyFile     = "id_ed25519"
+	defaultWaitTimeout = 10 * time.Second
+	// El servidor descarta las copias durante la ventana anti-replay (5 s por defecto).
+	defaultRepeatInterval = 250 * time.Millisecond
 )
 
+// knockOptions reúne los parámetros de un knock, procedentes de los flags y,
+// opcionalmente, de un perfil de client.yaml.
+type knockOptions struct {
+	Host         string
+	Port         int
+	Action       string
+	KeyFile      string
+	Args         string
+	RevertNow    bool
+	Extend       int
+	Approve      string
+	SealKey      string
+	Wait         bool
+	WaitTimeout  time.Duration
+	Repeat       int
+	Interval     time.Duration
+	ServerPubKey string
+	ProfilesFile string
+	PassphraseFD int
+	Agent        bool
+	AgentKey     string
+	CertFile     string
+
+	profileArgs map[string]string
+	// outputWriter recibe la salida devuelta por el servidor. En modo connect es
+	// stderr, porque stdout transporta la conexión TCP.
+	outputWriter io.Writer
+}
+
 func main() {
-	// 1. Configurar y parsear los argumentos de la línea de comandos.
-	host := flag.String("host", "", "Host o dirección IP del servidor GhostKnock (requerido)")
-	port := flag.Int("port", 3001, "Puerto UDP en el que el servidor escucha")
-	action := flag.String("action", "", "ActionID a solicitar (requerido)")
-	keyFile := flag.String("key", "", "Ruta a la clave privada ed25519 (por defecto: ~/.config/ghostknock/id_ed25519)")
-	// Nuevo flag para argumentos
-	args := flag.String("args", "", "Argumentos opcionales para la acción, formato: clave=valor,clave2=valor2")
-	flag.Parse()
-
-	if *host == "" || *action == "" {
-		fmt.Println("Error: los argumentos -host y -action son requeridos.")
+	if len(os.Args) > 1 && os.Args[1] == "connect" {
+		runConnect(os.Args[2:])
+		return
+	}
+
+	opts := &knockOptions{outputWriter: os.Stdout}
+	opts.registerFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Uso: %s [perfil [acción]] [flags]\n       %s connect [perfil [acción]] [flags] [-- comando...]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	positional := parseInterspersed(flag.CommandLine, os.Args[1:])
+	if err := opts.resolve(flag.CommandLine, positional); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	log.SetFlags(0)
-	log.Printf("Preparando knock para la acción '%s' en %s:%d...", *action, *host, *port)
+	os.Exit(sendKnock(opts))
+}
+
+// registerFlags declara en fs los flags comunes a todos los modos del cliente.
+func (o *knockOptions) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Host, "host", "", "Host o dirección IP del servidor GhostKnock (requerido)")
+	fs.IntVar(&o.Port, "port", 3001, "Puerto UDP en el que el servidor escucha")
+	fs.StringVar(&o.Action, "action", "", "ActionID a solicitar (requerido)")
+	fs.StringVar(&o.KeyFile, "key", "", "Ruta a la clave privada ed25519, propia o de OpenSSH (por defecto: ~/.config/ghostknock/id_ed25519)")
+	fs.StringVar(&o.Args, "args", "", "Argumentos opcionales para la acción, formato: clave=valor,clave2=valor2")
+	fs.BoolVar(&o.RevertNow, "revert-now", false, "Ejecuta ya la reversión pendiente de esta acción en lugar de dispararla de nuevo")
+	fs.IntVar(&o.Extend, "extend", 0, "Reprograma la reversión pendiente de esta acción para dentro de N segundos")
+	fs.StringVar(&o.Approve, "approve", "", "Aprueba la solicitud pendiente con este ID de una acción con 'require_approvals'")
+	fs.StringVar(&o.SealKey, "seal-key", "", "Clave pública X25519 del servidor (Base64) para cifrar el payload (opcional)")
+	fs.BoolVar(&o.Wait, "wait", false, "Espera el acuse de recibo firmado del servidor y sale con error si la acción no tuvo éxito")
+	fs.DurationVar(&o.WaitTimeout, "wait-timeout", defaultWaitTimeout, "Tiempo máximo de espera del acuse de recibo con -wait")
+	fs.IntVar(&o.Repeat, "repeat", 1, "Número de veces que se envía el mismo knock, para redes con pérdidas (el servidor lo ejecuta una sola vez)")
+	fs.DurationVar(&o.Interval, "interval", defaultRepeatInterval, "Pausa entre envíos con -repeat")
+	fs.StringVar(&o.ServerPubKey, "server-pubkey", "", "Clave pública ed25519 del servidor (Base64) con la que verificar el acuse de recibo")
+	fs.StringVar(&o.ProfilesFile, "profiles", defaultProfilesPath(), "Archivo de perfiles del cliente")
+	fs.IntVar(&o.PassphraseFD, "passphrase-fd", -1, "Descriptor de archivo del que leer la frase de paso de una clave cifrada (alternativa a "+keyfile.PassphraseEnv+")")
+	fs.BoolVar(&o.Agent, "agent", false, "Firma con una clave ed25519 de ssh-agent (SSH_AUTH_SOCK) en lugar de leer un archivo de clave")
+	fs.StringVar(&o.CertFile, "cert", "", "Certificado firmado por la CA del equipo (ghostknock-keygen sign-cert) que se adjunta al knock")
+	fs.StringVar(&o.AgentKey, "agent-key", "", "Huella (SHA256:...) o comentario de la clave del agente; necesario si tiene varias claves ed25519 (implica -agent)")
+}
+
+// resolve aplica el perfil indicado por los argumentos posicionales y valida
+// la combinación de opciones. Los flags explícitos prevalecen sobre el perfil.
+func (o *knockOptions) resolve(fs *flag.FlagSet, positional []string) error {
+	if len(positional) > 2 {
+		return errors.New("se esperaba como mucho un perfil y una acción")
+	}
+	if len(positional) > 0 {
+		profile, err := loadProfile(o.ProfilesFile, positional[0])
+		if err != nil {
+			return err
+		}
+		explicit := setFlags(fs)
+		applyString := func(name string, dst *string, value string) {
+			if !explicit[name] && value != "" {
+				*dst = value
+			}
+		}
+		applyString("host", &o.Host, profile.Host)
+		applyString("key", &o.KeyFile, profile.Key)
+		applyString("action", &o.Action, profile.Action)
+		applyString("seal-key", &o.SealKey, profile.SealKey)
+		applyString("server-pubkey", &o.ServerPubKey, profile.ServerPubKey)
+		applyString("agent-key", &o.AgentKey, profile.AgentKey)
+		applyString("cert", &o.CertFile, profile.Cert)
+		if !explicit["agent"] && !explicit["key"] && profile.Agent {
+			o.Agent = true
+		}
+		if !explicit["port"] && profile.Port != 0 {
+			o.Port = profile.Port
+		}
+		if !explicit["wait"] && profile.Wait {
+			o.Wait = true
+		}
+		if len(positional) == 2 && !explicit["action"] {
+			o.Action = positional[1]
+		}
+		o.profileArgs = profile.Args
+	}
+
+	if o.Host == "" || o.Action == "" {
+		return errors.New("los argumentos -host y -action son requeridos (o un perfil que los defina)")
+	}
+	if o.RevertNow && o.Extend != 0 {
+		return errors.New("-revert-now y -extend son incompatibles")
+	}
+	if o.Approve != "" && (o.RevertNow || o.Extend != 0) {
+		return errors.New("-approve es incompatible con -revert-now y -extend")
+	}
+	if o.Extend < 0 {
+		return errors.New("-extend debe ser un número positivo de segundos")
+	}
+	if o.Repeat < 1 {
+		return errors.New("-repeat debe ser al menos 1")
+	}
+	if o.Wait && o.ServerPubKey == "" {
+		return errors.New("-wait requiere -server-pubkey para verificar la respuesta del servidor")
+	}
+	if o.AgentKey != "" {
+		o.Agent = true
+	}
+	if o.Agent && setFlags(fs)["key"] {
+		return errors.New("-agent y -key son incompatibles")
+	}
+	return nil
+}
+
+// loadSigner devuelve el firmante del knock y una función que lo libera. Con
+// -agent la clave se queda en ssh-agent; en otro caso se lee del archivo de
+// clave (en bruto, cifrado o de OpenSSH), pidiendo la frase de paso si hace falta.
+func loadSigner(o *knockOptions) (crypto.Signer, func()) {
+	if o.Agent {
+		signer, err := connectAgent(o.AgentKey)
+		if err != nil {
+			log.Fatalf("FATAL: No se pudo usar ssh-agent: %v", err)
+		}
+		log.Printf("Usando la clave de ssh-agent %s", signer.Description())
+		return signer, func() { signer.Close() }
+	}
 
-	// 2. DETERMINAR LA RUTA DE LA CLAVE PRIVADA
 	var finalKeyPath string
-	if *keyFile != "" {
-		finalKeyPath = *keyFile
+	if o.KeyFile != "" {
+		finalKeyPath = o.KeyFile
 		log.Printf("Usando clave privada especificada: %s", finalKeyPath)
 	} else {
 		homeDir, err := os.UserHomeDir()
@@ -52,23 +197,100 @@ func main() {
 		log.Printf("Usando clave privada por defecto: %s", finalKeyPath)
 	}
 
-	// 3. Cargar la clave privada del fichero.
-	privateKeyBytes, err := os.ReadFile(finalKeyPath)
-	if err != nil {
+	privateKey, err := keyfile.Load(finalKeyPath, func() ([]byte, error) {
+		return keyfile.ReadPassphrase(o.PassphraseFD, fmt.Sprintf("Frase de paso para '%s': ", finalKeyPath))
+	})
+	if errors.Is(err, os.ErrNotExist) {
 		log.Fatalf("FATAL: No se pudo leer la clave privada '%s'. ¿Ejecutaste ghostknock-keygen? Error: %v", finalKeyPath, err)
 	}
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo cargar la clave privada '%s': %v", finalKeyPath, err)
+	}
+	return privateKey, func() {}
+}
+
+// loadCertificate lee el certificado y comprueba que corresponde a la clave con
+// la que se firmará el knock. Un certificado fuera de su validez solo genera un
+// aviso: es el servidor quien decide con su reloj.
+func loadCertificate(path string, publicKey ed25519.PublicKey) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo leer el certificado '%s': %v", path, err)
+	}
+	certBytes, err := protocol.DecodeCertificatePEM(data)
+	if err != nil {
+		log.Fatalf("FATAL: Certificado inválido en '%s': %v", path, err)
+	}
+	cert, err := protocol.ParseCertificate(certBytes)
+	if err != nil {
+		log.Fatalf("FATAL: Certificado inválido en '%s': %v", path, err)
+	}
+	if !cert.MatchesKey(publicKey) {
+		log.Fatalf("FATAL: El certificado '%s' no corresponde a la clave privada utilizada.", path)
+	}
+
+	now := time.Now()
+	if now.Before(cert.ValidFrom()) || !now.Before(cert.ValidUntil()) {
+		log.Printf("Aviso: el certificado solo es válido entre %s y %s; el servidor probablemente lo rechazará.", cert.ValidFrom().Format(time.RFC3339), cert.ValidUntil().Format(time.RFC3339))
+	}
+	log.Printf("Adjuntando el certificado de '%s' (serie %s, válido hasta %s).", cert.Subject, cert.Serial, cert.ValidUntil().Format(time.RFC3339))
+	return certBytes
+}
 
-	if len(privateKeyBytes) != ed25519.PrivateKeySize {
-		log.Fatalf("FATAL: El archivo de clave privada '%s' tiene un tamaño incorrecto. Se esperaba %d bytes, pero tiene %d.", finalKeyPath, ed25519.PrivateKeySize, len(privateKeyBytes))
+// sendKnock construye, firma y envía el knock. Devuelve el código de salida del
+// cliente: con -wait refleja el acuse de recibo del servidor.
+func sendKnock(o *knockOptions) int {
+	var serverKey ed25519.PublicKey
+	if o.Wait {
+		key, err := base64.StdEncoding.DecodeString(o.ServerPubKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Fatalf("FATAL: -server-pubkey no es una clave pública ed25519 válida en Base64.")
+		}
+		serverKey = ed25519.PublicKey(key)
 	}
-	privateKey := ed25519.PrivateKey(privateKeyBytes)
+
+	log.SetFlags(0)
+	log.Printf("Preparando knock para la acción '%s' en %s:%d...", o.Action, o.Host, o.Port)
+
+	// 2-3. Obtener el firmante: ssh-agent o el archivo de clave privada.
+	signer, closeSigner := loadSigner(o)
+	defer closeSigner()
 
 	// 4. Crear y rellenar el payload.
-	payload := protocol.NewPayload(*action)
+	payload := protocol.NewPayload(o.Action)
+	switch {
+	case o.RevertNow:
+		payload.Verb = protocol.VerbRevertNow
+		log.Printf("Solicitando la reversión inmediata de '%s'.", o.Action)
+	case o.Extend > 0:
+		payload.Verb = protocol.VerbExtend
+		payload.ExtendSeconds = o.Extend
+		log.Printf("Solicitando prorrogar '%s' %d segundos.", o.Action, o.Extend)
+	case o.Approve != "":
+		payload.Verb = protocol.VerbApprove
+		payload.RequestID = strings.ToLower(o.Approve)
+		log.Printf("Aprobando la solicitud %s de '%s'.", payload.RequestID, o.Action)
+	}
+
+	// Con -wait se adjunta una clave efímera para que el servidor pueda devolver,
+	// cifrada, la salida de las acciones con 'return_output'.
+	var replyKey *ecdh.PrivateKey
+	if o.Wait {
+		var err error
+		replyKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("FATAL: No se pudo generar la clave de respuesta: %v", err)
+		}
+		payload.ReplyKey = base64.StdEncoding.EncodeToString(replyKey.PublicKey().Bytes())
+	}
 
 	// --- LÓGICA DE PARSING DE ARGUMENTOS ---
-	if *args != "" {
-		pairs := strings.Split(*args, ",")
+	// Los argumentos del perfil se aplican primero para que -args pueda sustituirlos.
+	for key, value := range o.profileArgs {
+		payload.Params[key] = value
+	}
+	if o.Args != "" {
+		pairs := strings.Split(o.Args, ",")
 		for _, pair := range pairs {
 			if pair == "" {
 				continue
@@ -80,7 +302,7 @@ func main() {
 			}
 			key := strings.TrimSpace(kv[0])
 			value := strings.TrimSpace(kv[1])
-			
+
 			// Añadimos al mapa de parámetros
 			payload.Params[key] = value
 		}
@@ -95,24 +317,197 @@ func main() {
 		log.Fatalf("FATAL: No se pudo serializar el payload: %v", err)
 	}
 
-	// 5. Firmar el payload serializado.
-	signature := ed25519.Sign(privateKey, serializedPayload)
+	// 5. Si se indicó la clave del servidor, sellar el payload para que la acción y sus parámetros no viajen en claro.
+	var flags uint8
+	if o.SealKey != "" {
+		sealingKey, err := protocol.ParseSealingPublicKey(o.SealKey)
+		if err != nil {
+			log.Fatalf("FATAL: Clave de sellado inválida: %v", err)
+		}
+		serializedPayload, err = protocol.Seal(sealingKey, serializedPayload)
+		if err != nil {
+			log.Fatalf("FATAL: No se pudo cifrar el payload: %v", err)
+		}
+		flags |= protocol.FlagSealed
+		log.Printf("Payload cifrado para la clave de sellado del servidor.")
+	}
 
-	// 6. Construir el mensaje final: [firma][payload serializado]
-	finalMessage := append(signature, serializedPayload...)
+	// Con -cert, el certificado de la CA precede al payload y la firma del sobre cubre ambos.
+	if o.CertFile != "" {
+		cert := loadCertificate(o.CertFile, signer.Public().(ed25519.PublicKey))
+		serializedPayload, err = protocol.AttachCertificate(cert, serializedPayload)
+		if err != nil {
+			log.Fatalf("FATAL: No se pudo adjuntar el certificado: %v", err)
+		}
+		flags |= protocol.FlagCertificate
+	}
 
-	// 7. Enviar el mensaje en un único paquete UDP.
-	serverAddr := fmt.Sprintf("%s:%d", *host, *port)
-	conn, err := net.Dial("udp", serverAddr)
+	// 6. Firmar el payload y envolverlo en el sobre binario versionado.
+	envelope, err := protocol.NewEnvelope(serializedPayload, flags, signer)
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo firmar el knock: %v", err)
+	}
+	finalMessage, err := envelope.Marshal()
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo construir el mensaje: %v", err)
+	}
+
+	// 7. Enviar el mensaje en un único paquete UDP. Se usa un socket sin
+	// conectar porque el acuse de recibo no llega desde el puerto del knock.
+	serverAddr := net.JoinHostPort(o.Host, strconv.Itoa(o.Port))
+	udpAddr, err := net.ResolveUDPAddr("udp", serverAddr)
 	if err != nil {
 		log.Fatalf("FATAL: No se pudo resolver la dirección del servidor '%s': %v", serverAddr, err)
 	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		log.Fatalf("FATAL: No se pudo abrir el socket UDP: %v", err)
+	}
 	defer conn.Close()
 
-	bytesSent, err := conn.Write(finalMessage)
+	// Con -repeat se envía el mismo sobre firmado varias veces; el servidor
+	// reconoce las copias por su firma y solo procesa la primera que le llega.
+	for i := 0; i < o.Repeat; i++ {
+		if i > 0 {
+			time.Sleep(o.Interval)
+		}
+		bytesSent, err := conn.WriteToUDP(finalMessage, udpAddr)
+		if err != nil {
+			log.Fatalf("FATAL: Error al enviar el paquete UDP: %v", err)
+		}
+		if o.Repeat > 1 {
+			log.Printf("-- Knock enviado (%d bytes, envío %d de %d).", bytesSent, i+1, o.Repeat)
+		} else {
+			log.Printf("-- Knock enviado (%d bytes).", bytesSent)
+		}
+	}
+	// Si la acción requiere aprobaciones, los aprobadores necesitan este ID.
+	if payload.Verb == protocol.VerbExecute {
+		log.Printf("ID de la solicitud: %s", payload.Nonce)
+	}
+
+	// 8. Con -wait, esperar y verificar el acuse de recibo del servidor.
+	if !o.Wait {
+		return 0
+	}
+	resp, err := waitForResponse(conn, udpAddr.IP, serverKey, payload.Nonce, o.WaitTimeout)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	printOutput(resp, replyKey, o.outputWriter)
+	return reportAck(resp.ack)
+}
+
+// response agrupa el acuse de recibo y los fragmentos de salida recibidos.
+type response struct {
+	ack    *protocol.Ack
+	chunks map[int][]byte
+}
+
+func (r *response) complete() bool {
+	return r.ack != nil && len(r.chunks) >= r.ack.OutputChunks
+}
+
+// waitForResponse espera el acuse de recibo del servidor y, si lo anuncia, los
+// fragmentos de salida del comando. Los paquetes de otros orígenes o con firma
+// inválida se ignoran hasta agotar el tiempo.
+func waitForResponse(conn *net.UDPConn, serverIP net.IP, serverKey ed25519.PublicKey, nonce string, timeout time.Duration) (*response, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	resp := &response{chunks: make(map[int][]byte)}
+	buf := make([]byte, protocol.MaxMessageSize)
+	for !resp.complete() {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if resp.ack != nil {
+					log.Printf("Aviso: solo se recibieron %d de %d fragmentos de la salida.", len(resp.chunks), resp.ack.OutputChunks)
+					return resp, nil
+				}
+				return nil, fmt.Errorf("no se recibió acuse de recibo en %s (¿tiene la acción 'acknowledge: true'?)", timeout)
+			}
+			return nil, fmt.Errorf("error al recibir el acuse de recibo: %w", err)
+		}
+		if !from.IP.Equal(serverIP) {
+			continue
+		}
+
+		env, err := protocol.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		if env.Flags&protocol.FlagOutput != 0 {
+			chunk, err := protocol.ParseOutputChunk(buf[:n], serverKey, nonce)
+			if err != nil {
+				log.Printf("Fragmento de salida ignorado: %v", err)
+				continue
+			}
+			resp.chunks[chunk.Index] = chunk.Data
+			continue
+		}
+		ack, err := protocol.ParseAck(buf[:n], serverKey, nonce)
+		if err != nil {
+			log.Printf("Respuesta ignorada: %v", err)
+			continue
+		}
+		resp.ack = ack
+	}
+	return resp, nil
+}
+
+// printOutput reensambla, descifra y muestra la salida del comando.
+func printOutput(resp *response, replyKey *ecdh.PrivateKey, stdout io.Writer) {
+	if resp.ack.OutputChunks == 0 {
+		return
+	}
+	if len(resp.chunks) < resp.ack.OutputChunks {
+		log.Printf("Aviso: la salida del comando está incompleta y no se puede descifrar.")
+		return
+	}
+
+	ordered := make([][]byte, resp.ack.OutputChunks)
+	for i := range ordered {
+		ordered[i] = resp.chunks[i]
+	}
+	output, err := protocol.OpenOutput(ordered, replyKey)
 	if err != nil {
-		log.Fatalf("FATAL: Error al enviar el paquete UDP: %v", err)
+		log.Printf("Aviso: no se pudo descifrar la salida del comando: %v", err)
+		return
 	}
 
-	log.Printf("-- Knock enviado (%d bytes).", bytesSent)
+	stdout.Write(output.Stdout)
+	os.Stderr.Write(output.Stderr)
+	if output.Truncated {
+		log.Printf("Aviso: la salida fue recortada por el servidor ('max_output_bytes').")
+	}
+}
+
+// reportAck muestra el acuse de recibo y devuelve el código de salida del cliente.
+func reportAck(ack *protocol.Ack) int {
+	switch ack.Status {
+	case protocol.AckSuccess, protocol.AckAccepted:
+		if ack.ExitCode != nil {
+			log.Printf("-- Servidor: %s (código de salida %d).", ack.Status, *ack.ExitCode)
+		} else {
+			log.Printf("-- Servidor: %s.", ack.Status)
+		}
+		return 0
+	case protocol.AckPending:
+		log.Printf("-- Servidor: %s (%s).", ack.Status, ack.Message)
+		return 0
+	default:
+		detail := ack.Message
+		if ack.ExitCode != nil {
+			detail = fmt.Sprintf("%s, código de salida %d", detail, *ack.ExitCode)
+		}
+		if detail != "" {
+			log.Printf("-- Servidor: %s (%s).", ack.Status, strings.TrimPrefix(detail, ", "))
+		} else {
+			log.Printf("-- Servidor: %s.", ack.Status)
+		}
+		return 1
+	}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultProfilesFile = "client.yaml"
+
+// Profile agrupa los valores de un servidor con nombre en client.yaml. Los
+// flags de la línea de comandos siempre tienen prioridad sobre el perfil.
+type Profile struct {
+	Host         string            `yaml:"host"`
+	Port         int               `yaml:"port,omitempty"`
+	Key          string            `yaml:"key,omitempty"`
+	Agent        bool              `yaml:"agent,omitempty"`     // Firma con ssh-agent en lugar de Key.
+	AgentKey     string            `yaml:"agent_key,omitempty"` // Huella o comentario de la clave del agente.
+	Cert         string            `yaml:"cert,omitempty"`      // Certificado de la CA del equipo.
+	Action       string            `yaml:"action,omitempty"`    // Acción por defecto si no se indica otra.
+	Args         map[string]string `yaml:"args,omitempty"`      // Argumentos por defecto; -args los amplía o sustituye.
+	SealKey      string            `yaml:"seal_key,omitempty"`
+	ServerPubKey string            `yaml:"server_pubkey,omitempty"`
+	Wait         bool              `yaml:"wait,omitempty"`
+}
+
+// ClientConfig es la estructura raíz de client.yaml.
+type ClientConfig struct {
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// defaultProfilesPath devuelve ~/.config/ghostknock/client.yaml.
+func defaultProfilesPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return defaultProfilesFile
+	}
+	return filepath.Join(homeDir, ".config", "ghostknock", defaultProfilesFile)
+}
+
+// loadProfile lee client.yaml y devuelve el perfil con el nombre indicado.
+func loadProfile(path, name string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer el archivo de perfiles '%s': %w", path, err)
+	}
+
+	var cfg ClientConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error al parsear el archivo de perfiles '%s': %w", path, err)
+	}
+
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("el perfil '%s' no existe en '%s'", name, path)
+	}
+	if profile.Host == "" {
+		return nil, fmt.Errorf("el perfil '%s' no define 'host'", name)
+	}
+	profile.Key = expandHome(profile.Key)
+	profile.Cert = expandHome(profile.Cert)
+	return &profile, nil
+}
+
+// expandHome sustituye un '~/' inicial por el directorio home del usuario.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, path[2:])
+}
+
+// parseInterspersed parsea los flags permitiendo que aparezcan después de los
+// argumentos posicionales (ej. 'ghostknock prod ssh -wait'), y devuelve estos últimos.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		// Con flag.ExitOnError, Parse termina el proceso si hay un flag inválido.
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// setFlags devuelve los nombres de los flags indicados explícitamente.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
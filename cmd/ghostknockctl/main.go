@@ -0,0 +1,146 @@
+// ghostknockctl es la herramienta de administración local de ghostknockd. Habla
+// con el demonio a través de su socket de control (solo accesible por root).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/control"
+)
+
+const usageText = `Uso: ghostknockctl [-socket ruta] [-json] <comando>
+
+Comandos:
+  status                            Muestra reversiones pendientes, cooldowns y limitadores de IP
+  revert run <id>                   Ejecuta ya una reversión pendiente
+  revert cancel <id>                Descarta una reversión pendiente sin ejecutarla
+  cooldown clear <usuario> <acción> Elimina el cooldown de una acción para un usuario
+  reload                            Recarga y valida config.yaml (equivale a SIGHUP)
+
+Flags:
+`
+
+func main() {
+	log.SetFlags(0)
+
+	socketPath := flag.String("socket", config.DefaultControlSocket, "Ruta al socket de control de ghostknockd")
+	jsonOutput := flag.Bool("json", false, "Muestra la respuesta en JSON en lugar de en tablas")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usageText)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	req, err := buildRequest(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	resp, err := control.Call(*socketPath, req)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(resp); err != nil {
+			log.Fatalf("FATAL: No se pudo codificar la respuesta: %v", err)
+		}
+		return
+	}
+
+	if resp.Status != nil {
+		printStatus(resp.Status)
+		return
+	}
+	if req.Command == control.CmdRunRevert {
+		// El demonio no espera al comando: si falla, la reversión seguirá en 'status'.
+		fmt.Println("OK: reversión lanzada en segundo plano")
+		return
+	}
+	fmt.Println("OK")
+}
+
+// buildRequest traduce los argumentos posicionales a una petición de control.
+func buildRequest(args []string) (control.Request, error) {
+	if len(args) == 0 {
+		return control.Request{}, fmt.Errorf("falta el comando")
+	}
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		return control.Request{Command: control.CmdStatus}, nil
+	case args[0] == "reload" && len(args) == 1:
+		return control.Request{Command: control.CmdReload}, nil
+	case args[0] == "revert" && len(args) == 3 && args[1] == "run":
+		return control.Request{Command: control.CmdRunRevert, RevertID: args[2]}, nil
+	case args[0] == "revert" && len(args) == 3 && args[1] == "cancel":
+		return control.Request{Command: control.CmdCancelRevert, RevertID: args[2]}, nil
+	case args[0] == "cooldown" && len(args) == 4 && args[1] == "clear":
+		return control.Request{Command: control.CmdClearCooldown, User: args[2], ActionID: args[3]}, nil
+	}
+	return control.Request{}, fmt.Errorf("comando o argumentos no reconocidos: %v", args)
+}
+
+func printStatus(status *control.Status) {
+	now := time.Now()
+	fmt.Printf("Demonio activo desde %s (configuración cargada %s)\n\n",
+		status.StartedAt.Format(time.RFC3339), status.ConfigLoadedAt.Format(time.RFC3339))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "REVERSIONES PENDIENTES (%d)\n", len(status.Reverts))
+	if len(status.Reverts) > 0 {
+		fmt.Fprintln(w, "ID\tUSUARIO\tACCIÓN\tIP ORIGEN\tVENCE EN")
+		for _, rev := range status.Reverts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", rev.ID, rev.User, rev.ActionID, rev.SourceIP, rev.DueAt.Sub(now).Round(time.Second))
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "COOLDOWNS ACTIVOS (%d)\n", len(status.Cooldowns))
+	if len(status.Cooldowns) > 0 {
+		fmt.Fprintln(w, "USUARIO\tACCIÓN\tÚLTIMA EJECUCIÓN\tRESTANTE")
+		for _, cd := range status.Cooldowns {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cd.User, cd.ActionID, cd.LastExecution.Format(time.RFC3339), cd.ExpiresAt.Sub(now).Round(time.Second))
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "LIMITADORES DE IP (%d)\n", len(status.RateLimits))
+	if len(status.RateLimits) > 0 {
+		fmt.Fprintln(w, "IP ORIGEN\tTOKENS\tLIMITADA\tÚLTIMO PAQUETE")
+		for _, rl := range status.RateLimits {
+			limited := "no"
+			if rl.Limited {
+				limited = "sí"
+			}
+			fmt.Fprintf(w, "%s\t%.2f\t%s\t%s\n", rl.SourceIP, rl.Tokens, limited, rl.LastSeen.Format(time.RFC3339))
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "APROBACIONES PENDIENTES (%d)\n", len(status.Approvals))
+	if len(status.Approvals) > 0 {
+		fmt.Fprintln(w, "ID\tUSUARIO\tACCIÓN\tIP ORIGEN\tAPROBACIONES\tCADUCA EN")
+		for _, ap := range status.Approvals {
+			approvals := fmt.Sprintf("%d/%d", len(ap.Approvers), ap.Required)
+			if len(ap.Approvers) > 0 {
+				approvals += " (" + strings.Join(ap.Approvers, ", ") + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ap.RequestID, ap.User, ap.ActionID, ap.SourceIP, approvals, ap.ExpiresAt.Sub(now).Round(time.Second))
+		}
+	}
+	w.Flush()
+}
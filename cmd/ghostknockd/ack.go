@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/executor"
+	"github.com/your-org/ghostknock/internal/listener"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// sendAck responde al cliente con un acuse de recibo firmado si la acción lo
+// tiene activado. Solo se llama para knocks ya autenticados y autorizados, de
+// modo que el puerto sigue sin responder a terceros.
+func sendAck(cfg *config.Config, action config.Action, packetInfo listener.PacketInfo, ack *protocol.Ack) {
+	sendResponse(cfg, action, packetInfo, ack, nil)
+}
+
+// sendResponse envía los fragmentos de salida, si los hay, seguidos del acuse de recibo.
+func sendResponse(cfg *config.Config, action config.Action, packetInfo listener.PacketInfo, ack *protocol.Ack, output [][]byte) {
+	if !(action.Acknowledge || action.ReturnOutput) || cfg.Server.SigningKey == nil || packetInfo.SourcePort == 0 {
+		return
+	}
+
+	ack.OutputChunks = len(output)
+	message, err := ack.Marshal(cfg.Server.SigningKey)
+	if err != nil {
+		slog.Error("No se pudo construir el acuse de recibo", "error", err)
+		return
+	}
+
+	clientAddr := &net.UDPAddr{IP: packetInfo.SourceIP, Port: packetInfo.SourcePort}
+	conn, err := net.DialUDP("udp", nil, clientAddr)
+	if err != nil {
+		slog.Error("No se pudo enviar el acuse de recibo", "client", clientAddr.String(), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, chunk := range output {
+		if _, err := conn.Write(chunk); err != nil {
+			slog.Error("No se pudo enviar la salida del comando", "client", clientAddr.String(), "error", err)
+			return
+		}
+	}
+	if _, err := conn.Write(message); err != nil {
+		slog.Error("No se pudo enviar el acuse de recibo", "client", clientAddr.String(), "error", err)
+		return
+	}
+	slog.Debug("Acuse de recibo enviado", "client", clientAddr.String(), "status", ack.Status, "output_chunks", len(output))
+}
+
+// sealResultOutput cifra la salida del comando para la clave de respuesta del
+// cliente. Sin clave de respuesta la salida no se envía: nunca viaja en claro.
+func sealResultOutput(cfg *config.Config, action config.Action, payload *protocol.Payload, result executor.Result) [][]byte {
+	if !action.ReturnOutput || payload.ReplyKey == "" || cfg.Server.SigningKey == nil {
+		return nil
+	}
+
+	replyKey, err := protocol.ParseSealingPublicKey(payload.ReplyKey)
+	if err != nil {
+		slog.Warn("Salida no enviada: la clave de respuesta del cliente no es válida", "action_id", payload.ActionID, "error", err)
+		return nil
+	}
+
+	output := protocol.Output{
+		Stdout:    result.Stdout,
+		Stderr:    result.Stderr,
+		Truncated: result.Truncated,
+	}
+	chunks, err := protocol.SealOutput(output, payload.Nonce, replyKey, cfg.Server.SigningKey)
+	if err != nil {
+		slog.Error("No se pudo cifrar la salida del comando", "action_id", payload.ActionID, "error", err)
+		return nil
+	}
+	return chunks
+}
+
+// ackForResult traduce el resultado de la ejecución a un acuse de recibo.
+func ackForResult(requestNonce string, result executor.Result, err error) *protocol.Ack {
+	if err == nil {
+		ack := protocol.NewAck(requestNonce, protocol.AckSuccess)
+		ack.ExitCode = &result.ExitCode
+		return ack
+	}
+
+	ack := protocol.NewAck(requestNonce, protocol.AckFailed)
+	if result.Command == "" {
+		// No se detalla el motivo para no revelar la configuración al cliente.
+		ack.Message = "rejected"
+		return ack
+	}
+	ack.Message = result.Status
+	if result.ExitCode >= 0 {
+		ack.ExitCode = &result.ExitCode
+	}
+	return ack
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/control"
+	"github.com/your-org/ghostknock/internal/executor"
+)
+
+// Server implementa control.Handler para atender el socket de administración.
+var _ control.Handler = (*Server)(nil)
+
+// errDaemonStopping indica que el demonio se está deteniendo y ya no atiende recargas.
+var errDaemonStopping = errors.New("el demonio se está deteniendo, no se puede recargar la configuración")
+
+// Status devuelve una instantánea de las reversiones, cooldowns, limitadores y
+// solicitudes de aprobación activos.
+func (s *Server) Status() control.Status {
+	cfg := s.currentConfig()
+	now := time.Now()
+
+	s.configMutex.RLock()
+	status := control.Status{
+		StartedAt:      s.startedAt,
+		ConfigLoadedAt: s.configLoadedAt,
+		Reverts:        []control.Revert{},
+		Cooldowns:      []control.Cooldown{},
+		RateLimits:     []control.RateLimitedIP{},
+		Approvals:      []control.Approval{},
+	}
+	s.configMutex.RUnlock()
+
+	for _, rev := range s.reverts.List() {
+		status.Reverts = append(status.Reverts, control.Revert{
+			ID:        rev.ID,
+			User:      rev.User,
+			ActionID:  rev.ActionID,
+			SourceIP:  rev.SourceIP,
+			CreatedAt: rev.CreatedAt,
+			DueAt:     rev.DueAt,
+		})
+	}
+
+	s.cacheMutex.RLock()
+	for key, lastExecution := range s.actionCooldowns {
+		action, ok := cfg.Actions[key.action]
+		if !ok {
+			continue
+		}
+		expiresAt := lastExecution.Add(effectiveCooldown(action))
+		if !expiresAt.After(now) {
+			continue
+		}
+		status.Cooldowns = append(status.Cooldowns, control.Cooldown{
+			User:          key.user,
+			ActionID:      key.action,
+			LastExecution: lastExecution,
+			ExpiresAt:     expiresAt,
+		})
+	}
+	s.cacheMutex.RUnlock()
+	sort.Slice(status.Cooldowns, func(i, j int) bool { return status.Cooldowns[i].ExpiresAt.Before(status.Cooldowns[j].ExpiresAt) })
+
+	s.limitersMutex.Lock()
+	for ip, info := range s.ipLimiters {
+		tokens := info.limiter.TokensAt(now)
+		status.RateLimits = append(status.RateLimits, control.RateLimitedIP{
+			SourceIP: ip,
+			Tokens:   tokens,
+			Limited:  tokens < 1,
+			LastSeen: info.lastSeen,
+		})
+	}
+	s.limitersMutex.Unlock()
+	sort.Slice(status.RateLimits, func(i, j int) bool { return status.RateLimits[i].SourceIP < status.RateLimits[j].SourceIP })
+
+	for _, p := range s.approvals.list() {
+		status.Approvals = append(status.Approvals, control.Approval{
+			RequestID: p.ID,
+			User:      p.Requester.Name,
+			ActionID:  p.ActionID,
+			SourceIP:  p.Requester.SourceIP.String(),
+			Approvers: p.approvers(),
+			Required:  p.required(cfg.Actions[p.ActionID].RequireApprovals),
+			ExpiresAt: p.ExpiresAt,
+		})
+	}
+	sort.Slice(status.Approvals, func(i, j int) bool { return status.Approvals[i].ExpiresAt.Before(status.Approvals[j].ExpiresAt) })
+
+	return status
+}
+
+// RunRevert lanza de inmediato una reversión pendiente, sin esperar a que su
+// comando termine.
+func (s *Server) RunRevert(id string) error {
+	switch err := s.reverts.RunNow(id); {
+	case errors.Is(err, executor.ErrRevertRunning):
+		return fmt.Errorf("la reversión '%s' ya se está ejecutando", id)
+	case err != nil:
+		return fmt.Errorf("no existe ninguna reversión pendiente con ID '%s'", id)
+	}
+	return nil
+}
+
+// CancelRevert descarta una reversión pendiente sin ejecutarla.
+func (s *Server) CancelRevert(id string) error {
+	if !s.reverts.Cancel(id) {
+		return fmt.Errorf("no existe ninguna reversión pendiente con ID '%s'", id)
+	}
+	return nil
+}
+
+// ClearCooldown elimina el cooldown de una acción para un usuario o para el
+// titular de un certificado, que no figura en la configuración.
+func (s *Server) ClearCooldown(userName, actionID string) error {
+	key := cooldownKey{userName, actionID}
+	s.cacheMutex.Lock()
+	_, exists := s.actionCooldowns[key]
+	delete(s.actionCooldowns, key)
+	s.cacheMutex.Unlock()
+	if !exists {
+		return fmt.Errorf("el usuario o titular de certificado '%s' no tiene cooldown registrado para la acción '%s'", userName, actionID)
+	}
+	return nil
+}
+
+// Reload pide al bucle principal que recargue la configuración y espera el
+// resultado. Si el bucle ya ha terminado, falla en lugar de bloquearse.
+func (s *Server) Reload() error {
+	reply := make(chan error, 1)
+	select {
+	case s.reloadRequests <- reply:
+	case <-s.stopped:
+		return errDaemonStopping
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-s.stopped:
+		return errDaemonStopping
+	}
+}
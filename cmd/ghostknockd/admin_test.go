@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusCooldownWithColonInSubject(t *testing.T) {
+	publicKey, _ := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, openSSHAction+"    cooldown_seconds: 60\n"))
+	// Los titulares de certificado no figuran en la configuración y pueden
+	// contener ':'.
+	const subject = "ops:ana"
+	s.actionCooldowns[cooldownKey{subject, "open-ssh"}] = time.Now()
+
+	cooldowns := s.Status().Cooldowns
+	if len(cooldowns) != 1 || cooldowns[0].User != subject || cooldowns[0].ActionID != "open-ssh" {
+		t.Fatalf("cooldowns = %+v, se esperaba uno de %q para open-ssh", cooldowns, subject)
+	}
+	if err := s.ClearCooldown(subject, "open-ssh"); err != nil {
+		t.Fatalf("ClearCooldown: %v", err)
+	}
+	if len(s.actionCooldowns) != 0 {
+		t.Error("ClearCooldown no eliminó el cooldown")
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/audit"
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/listener"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// approvalParty identifica a quien participa en una solicitud, solicitante o
+// aprobador, tal como se autenticó: con su clave o con un certificado y desde
+// qué IP. Así se le puede volver a autorizar con la configuración vigente
+// antes de ejecutar.
+type approvalParty struct {
+	Name     string
+	Key      ed25519.PublicKey
+	Cert     *protocol.Certificate
+	SourceIP net.IP
+}
+
+// pendingApproval es una solicitud de una acción con 'require_approvals' que
+// espera las aprobaciones de otros usuarios. Su ID es el nonce del knock original.
+type pendingApproval struct {
+	ID        string
+	ActionID  string
+	Requester approvalParty
+	Payload   *protocol.Payload
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Required es el 'require_approvals' de la acción al hacer la solicitud.
+	Required int
+	// Approvals son las aprobaciones recibidas, en orden de llegada.
+	Approvals []approvalParty
+}
+
+func (p *pendingApproval) hasApproved(user string) bool {
+	for _, approval := range p.Approvals {
+		if approval.Name == user {
+			return true
+		}
+	}
+	return false
+}
+
+// required devuelve las aprobaciones necesarias para completar la solicitud:
+// el mayor entre el valor al solicitarla y current, el de la configuración
+// vigente. Una recarga puede endurecer una solicitud pendiente, nunca relajarla.
+func (p *pendingApproval) required(current int) int {
+	return max(p.Required, current)
+}
+
+// approvers devuelve los nombres de quienes ya han aprobado la solicitud.
+func (p *pendingApproval) approvers() []string {
+	names := make([]string, 0, len(p.Approvals))
+	for _, approval := range p.Approvals {
+		names = append(names, approval.Name)
+	}
+	return names
+}
+
+// approvalResult es el resultado de registrar una aprobación.
+type approvalResult int
+
+const (
+	approvalRecorded approvalResult = iota
+	approvalCompleted
+	approvalUnknown
+	approvalSelf
+	approvalDuplicate
+)
+
+// approvalStore guarda las solicitudes pendientes de aprobación.
+type approvalStore struct {
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+}
+
+func newApprovalStore() *approvalStore {
+	return &approvalStore{pending: make(map[string]*pendingApproval)}
+}
+
+// add registra una solicitud nueva. Devuelve false, sin registrarla, si su
+// solicitante o su acción ya tienen el máximo de solicitudes pendientes.
+func (a *approvalStore) add(p *pendingApproval) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	byUser, byAction := 0, 0
+	for _, other := range a.pending {
+		// Las caducadas que aún no ha purgado expireApprovals no cuentan.
+		if !p.CreatedAt.Before(other.ExpiresAt) {
+			continue
+		}
+		if other.Requester.Name == p.Requester.Name {
+			byUser++
+		}
+		if other.ActionID == p.ActionID {
+			byAction++
+		}
+	}
+	if byUser >= maxPendingApprovalsPerUser || byAction >= maxPendingApprovalsPerAction {
+		return false
+	}
+	a.pending[p.ID] = p
+	return true
+}
+
+// approve registra la aprobación de approver sobre la solicitud id de la acción
+// actionID. Al alcanzar las aprobaciones necesarias (ver required, con current
+// como el valor vigente), la solicitud sale del almacén y el resultado es
+// approvalCompleted: solo un knock puede completarla.
+func (a *approvalStore) approve(id, actionID string, approver approvalParty, current int, now time.Time) (*pendingApproval, approvalResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, ok := a.pending[id]
+	if !ok || p.ActionID != actionID || !now.Before(p.ExpiresAt) {
+		return nil, approvalUnknown
+	}
+	if p.Requester.Name == approver.Name {
+		return p, approvalSelf
+	}
+	if p.hasApproved(approver.Name) {
+		return p, approvalDuplicate
+	}
+	p.Approvals = append(p.Approvals, approver)
+	if len(p.Approvals) < p.required(current) {
+		return p, approvalRecorded
+	}
+	delete(a.pending, id)
+	return p, approvalCompleted
+}
+
+// purge elimina y devuelve las solicitudes cuyo plazo ha vencido.
+func (a *approvalStore) purge(now time.Time) []*pendingApproval {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var expired []*pendingApproval
+	for id, p := range a.pending {
+		if !now.Before(p.ExpiresAt) {
+			expired = append(expired, p)
+			delete(a.pending, id)
+		}
+	}
+	return expired
+}
+
+// list devuelve una copia de las solicitudes pendientes.
+func (a *approvalStore) list() []pendingApproval {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]pendingApproval, 0, len(a.pending))
+	for _, p := range a.pending {
+		copied := *p
+		copied.Approvals = append([]approvalParty(nil), p.Approvals...)
+		list = append(list, copied)
+	}
+	return list
+}
+
+// requestApproval deja pendiente una acción que requiere aprobaciones y
+// responde al solicitante con el ID de la solicitud.
+func (s *Server) requestApproval(cfg *config.Config, user *config.User, publicKey ed25519.PublicKey, action config.Action, payload *protocol.Payload, packetInfo listener.PacketInfo) {
+	now := time.Now()
+	p := &pendingApproval{
+		ID:        payload.Nonce,
+		ActionID:  payload.ActionID,
+		Requester: approvalParty{Name: user.Name, Key: publicKey, Cert: user.Certificate, SourceIP: packetInfo.SourceIP},
+		Payload:   payload,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(action.ApprovalWindowSeconds) * time.Second),
+		Required:  action.RequireApprovals,
+	}
+	if !s.approvals.add(p) {
+		slog.Warn(
+			"Acción descartada",
+			"reason", "too_many_pending_approvals",
+			"request_id", p.ID,
+			"user", user.Name,
+			"action_id", payload.ActionID,
+			"source_ip", packetInfo.SourceIP.String(),
+		)
+		s.countDrop("too_many_pending_approvals", user.Name, payload.ActionID)
+		sendAck(cfg, action, packetInfo, protocol.NewAck(payload.Nonce, protocol.AckRejected))
+		return
+	}
+
+	slog.Info("Acción pendiente de aprobación",
+		"request_id", p.ID,
+		"user", user.Name,
+		"action_id", payload.ActionID,
+		"source_ip", packetInfo.SourceIP.String(),
+		"required_approvals", action.RequireApprovals,
+		"expires_at", p.ExpiresAt,
+	)
+	audit.Record(audit.Event{
+		Event:     audit.EventApprovalRequested,
+		User:      user.Name,
+		ActionID:  payload.ActionID,
+		SourceIP:  packetInfo.SourceIP.String(),
+		Nonce:     payload.Nonce,
+		RequestID: p.ID,
+		Params:    payload.Params,
+	})
+
+	ack := protocol.NewAck(payload.Nonce, protocol.AckPending)
+	ack.Message = fmt.Sprintf("solicitud %s pendiente de %d aprobaciones", p.ID, action.RequireApprovals)
+	sendAck(cfg, action, packetInfo, ack)
+}
+
+// handleApproval registra una aprobación y, si completa las requeridas,
+// ejecuta la acción pendiente una sola vez con los datos de la solicitud
+// original. Las respuestas van al aprobador.
+func (s *Server) handleApproval(cfg *config.Config, approver *config.User, publicKey ed25519.PublicKey, action config.Action, payload *protocol.Payload, packetInfo listener.PacketInfo) {
+	party := approvalParty{Name: approver.Name, Key: publicKey, Cert: approver.Certificate, SourceIP: packetInfo.SourceIP}
+	p, result := s.approvals.approve(payload.RequestID, payload.ActionID, party, action.RequireApprovals, time.Now())
+
+	reason := ""
+	switch result {
+	case approvalUnknown:
+		reason = "unknown_approval_request"
+	case approvalSelf:
+		reason = "self_approval"
+	case approvalDuplicate:
+		reason = "duplicate_approval"
+	}
+	if reason != "" {
+		slog.Warn("Aprobación descartada",
+			"reason", reason,
+			"request_id", payload.RequestID,
+			"user", approver.Name,
+			"action_id", payload.ActionID,
+			"source_ip", packetInfo.SourceIP.String(),
+		)
+		s.countDrop(reason, approver.Name, payload.ActionID)
+		sendAck(cfg, action, packetInfo, protocol.NewAck(payload.Nonce, protocol.AckRejected))
+		return
+	}
+
+	slog.Info("Aprobación registrada",
+		"request_id", p.ID,
+		"user", approver.Name,
+		"requester", p.Requester.Name,
+		"action_id", p.ActionID,
+		"source_ip", packetInfo.SourceIP.String(),
+		"approvals", len(p.Approvals),
+		"required_approvals", p.required(action.RequireApprovals),
+	)
+	audit.Record(audit.Event{
+		Event:     audit.EventApprovalGranted,
+		User:      approver.Name,
+		ActionID:  p.ActionID,
+		SourceIP:  packetInfo.SourceIP.String(),
+		Nonce:     payload.Nonce,
+		RequestID: p.ID,
+		Approvers: p.approvers(),
+	})
+
+	if result == approvalRecorded {
+		ack := protocol.NewAck(payload.Nonce, protocol.AckPending)
+		ack.Message = fmt.Sprintf("aprobación %d de %d registrada para la solicitud %s", len(p.Approvals), p.required(action.RequireApprovals), p.ID)
+		sendAck(cfg, action, packetInfo, ack)
+		return
+	}
+
+	// La autorización del solicitante y de los aprobadores pudo cambiar mientras
+	// se reunían las aprobaciones (revocación, caducidad, horario o recarga de la
+	// configuración).
+	if who, reason := s.recheckParties(cfg, p, time.Now()); reason != "" {
+		slog.Warn(
+			"Acción descartada",
+			"reason", reason,
+			"request_id", p.ID,
+			"user", who,
+			"requester", p.Requester.Name,
+			"action_id", p.ActionID,
+			"source_ip", p.Requester.SourceIP.String(),
+		)
+		s.countDrop(reason, who, p.ActionID)
+		sendAck(cfg, action, packetInfo, protocol.NewAck(payload.Nonce, protocol.AckRejected))
+		return
+	}
+
+	// La acción consume el cooldown del solicitante, como si la hubiera ejecutado él.
+	if remaining, onCooldown := s.cooldownRemaining(p.Requester.Name, p.ActionID, action); onCooldown {
+		slog.Warn(
+			"Acción descartada",
+			"reason", "cooldown_active",
+			"request_id", p.ID,
+			"user", p.Requester.Name,
+			"action_id", p.ActionID,
+			"remaining_seconds", remaining.Seconds(),
+		)
+		s.countDrop("cooldown_active", p.Requester.Name, p.ActionID)
+		sendAck(cfg, action, packetInfo, protocol.NewAck(payload.Nonce, protocol.AckCooldown))
+		return
+	}
+
+	slog.Info("Aprobaciones completas, ejecutando la acción",
+		"request_id", p.ID,
+		"user", p.Requester.Name,
+		"action_id", p.ActionID,
+		"source_ip", p.Requester.SourceIP.String(),
+		"approvers", p.approvers(),
+	)
+	s.executeAction(cfg, action, p.Requester.Name, p.Payload, p.Requester.SourceIP, payload, packetInfo)
+}
+
+// recheckParties vuelve a autorizar, con la configuración vigente, al
+// solicitante y a cada aprobador de una solicitud completa. Devuelve quién ya
+// no está autorizado y el motivo de descarte, o "" si todos lo siguen estando.
+func (s *Server) recheckParties(cfg *config.Config, p *pendingApproval, now time.Time) (string, string) {
+	if reason := s.recheckParty(cfg, p.Requester, p.ActionID, false, now); reason != "" {
+		return p.Requester.Name, reason
+	}
+	for _, approval := range p.Approvals {
+		if reason := s.recheckParty(cfg, approval, p.ActionID, true, now); reason != "" {
+			return approval.Name, reason
+		}
+	}
+	return "", ""
+}
+
+// recheckParty repite para un participante las comprobaciones que processKnock
+// hizo con su knock: solicitar la acción o, si asApprover, aprobarla.
+// Devuelve el motivo de descarte, o "" si sigue autorizado.
+func (s *Server) recheckParty(cfg *config.Config, party approvalParty, actionID string, asApprover bool, now time.Time) string {
+	notAuthorized := "requester_not_authorized"
+	if asApprover {
+		notAuthorized = "approver_not_authorized"
+	}
+
+	var user *config.User
+	if party.Cert != nil {
+		var err error
+		if user, err = cfg.CertificateUser(party.Cert); err != nil {
+			return notAuthorized
+		}
+		if s.certificateRevoked(cfg, party.Cert) {
+			return "revoked_certificate"
+		}
+	} else {
+		var publicKey ed25519.PublicKey
+		user, publicKey = cfg.UserByKeyID(protocol.KeyIDFromPublicKey(party.Key))
+		if user == nil || user.Name != party.Name || !publicKey.Equal(party.Key) {
+			return notAuthorized
+		}
+	}
+
+	switch {
+	case s.revoked.Contains(party.Key):
+		return "revoked_key"
+	case asApprover && !cfg.Actions[actionID].IsApprover(user.Name):
+		return "unauthorized_approver"
+	case !asApprover && !isActionAllowed(actionID, user.AllowedActions):
+		return "unauthorized_action"
+	case !user.Validity.Contains(now), !user.ActionValidity[actionID].Contains(now):
+		return "expired_key"
+	case !isSourceAllowed(user, party.SourceIP):
+		return "unauthorized_source_ip"
+	}
+	if _, schedule := outsideSchedule(user, cfg.Actions[actionID], now); schedule != nil {
+		return "outside_schedule"
+	}
+	return ""
+}
+
+// expireApprovals descarta las solicitudes cuyo plazo de aprobación ha vencido.
+func (s *Server) expireApprovals() {
+	for _, p := range s.approvals.purge(time.Now()) {
+		slog.Warn("Solicitud de aprobación caducada",
+			"request_id", p.ID,
+			"user", p.Requester.Name,
+			"action_id", p.ActionID,
+			"approvals", len(p.Approvals),
+		)
+		s.countDrop("approval_expired", p.Requester.Name, p.ActionID)
+		audit.Record(audit.Event{
+			Event:     audit.EventApprovalExpired,
+			User:      p.Requester.Name,
+			ActionID:  p.ActionID,
+			SourceIP:  p.Requester.SourceIP.String(),
+			RequestID: p.ID,
+			Approvers: p.approvers(),
+		})
+	}
+}
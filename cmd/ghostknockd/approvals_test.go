@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// approvalTeam prepara un servidor con la acción "deploy", que alice puede
+// solicitar y que requiere dos aprobaciones de bob y carol. dave es un usuario
+// más, sin permiso para aprobar. Cada usuario llama desde su propia IP.
+type approvalTeam struct {
+	s          *Server
+	keys       map[string]ed25519.PrivateKey
+	publicKeys map[string]ed25519.PublicKey
+	ips        map[string]net.IP
+	log        string
+	// serverKey firma los acuses, que estas acciones exigen.
+	serverKey     ed25519.PublicKey
+	serverKeyFile string
+}
+
+var approvalTeamUsers = []string{"alice", "bob", "carol", "dave"}
+
+func newApprovalTeam(t *testing.T) *approvalTeam {
+	t.Helper()
+	team := &approvalTeam{
+		keys:       make(map[string]ed25519.PrivateKey),
+		publicKeys: make(map[string]ed25519.PublicKey),
+		ips:        make(map[string]net.IP),
+		log:        filepath.Join(t.TempDir(), "deployments"),
+	}
+	for i, name := range approvalTeamUsers {
+		team.publicKeys[name], team.keys[name] = generateKey(t)
+		// Direcciones de loopback: los acuses se envían de verdad por UDP.
+		team.ips[name] = net.IPv4(127, 0, 0, byte(10+i))
+	}
+	var serverKey ed25519.PrivateKey
+	team.serverKey, serverKey = generateKey(t)
+	team.serverKeyFile = filepath.Join(t.TempDir(), "server_ed25519")
+	if err := os.WriteFile(team.serverKeyFile, serverKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	team.s = newTestServer(t, team.config(`["deploy"]`, 2, `["alice", "bob", "carol"]`))
+	return team
+}
+
+// config devuelve la configuración del equipo, con aliceActions como la lista
+// de acciones de alice y required aprobaciones de approvers para "deploy".
+func (team *approvalTeam) config(aliceActions string, required int, approvers string) string {
+	var users strings.Builder
+	for _, name := range approvalTeamUsers {
+		actions := `["deploy"]`
+		if name == "alice" {
+			actions = aliceActions
+		}
+		fmt.Fprintf(&users, "  - name: %q\n    public_key: %q\n    actions: %s\n", name, base64.StdEncoding.EncodeToString(team.publicKeys[name]), actions)
+	}
+	return fmt.Sprintf(`listener:
+  interface: "any"
+  port: 3001
+logging:
+  log_level: "info"
+server:
+  signing_key_file: %q
+users:
+%sactions:
+  "deploy":
+    command: "echo deployed >> %s"
+    acknowledge: true
+    require_approvals: %d
+    approvers: %s
+  "status":
+    command: "true"
+`, team.serverKeyFile, users.String(), team.log, required, approvers)
+}
+
+// request envía el knock de alice que solicita la acción y devuelve su ID.
+func (team *approvalTeam) request(t *testing.T) string {
+	t.Helper()
+	payload := protocol.NewPayload("deploy")
+	team.s.processKnock(signKnock(t, team.keys["alice"], payload, team.ips["alice"]))
+	return payload.Nonce
+}
+
+// listen abre el socket UDP en el que user espera los acuses, como ghostknock -wait.
+func (team *approvalTeam) listen(t *testing.T, user string) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: team.ips[user]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readAck espera el acuse del knock con el nonce dado.
+func (team *approvalTeam) readAck(t *testing.T, conn *net.UDPConn, nonce string) *protocol.Ack {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, protocol.MaxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no llegó el acuse de recibo: %v", err)
+	}
+	ack, err := protocol.ParseAck(buf[:n], team.serverKey, nonce)
+	if err != nil {
+		t.Fatalf("ParseAck: %v", err)
+	}
+	return ack
+}
+
+// approve envía la aprobación de user para la solicitud id.
+func (team *approvalTeam) approve(t *testing.T, user, id string) {
+	t.Helper()
+	payload := protocol.NewPayload("deploy")
+	payload.Verb = protocol.VerbApprove
+	payload.RequestID = id
+	team.s.processKnock(signKnock(t, team.keys[user], payload, team.ips[user]))
+}
+
+// deployments devuelve cuántas veces se ha ejecutado la acción.
+func (team *approvalTeam) deployments(t *testing.T) int {
+	t.Helper()
+	data, err := os.ReadFile(team.log)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "deployed")
+}
+
+// approvers devuelve quién ha aprobado la solicitud id, o false si ya no está pendiente.
+func (team *approvalTeam) approvers(id string) ([]string, bool) {
+	for _, p := range team.s.approvals.list() {
+		if p.ID == id {
+			return p.approvers(), true
+		}
+	}
+	return nil, false
+}
+
+func TestApprovalCompletesAfterRequiredApprovals(t *testing.T) {
+	team := newApprovalTeam(t)
+	id := team.request(t)
+	if approvers, pending := team.approvers(id); !pending || len(approvers) != 0 {
+		t.Fatalf("tras la solicitud: pendiente = %v, aprobadores = %v", pending, approvers)
+	}
+
+	team.approve(t, "bob", id)
+	if approvers, _ := team.approvers(id); len(approvers) != 1 || approvers[0] != "bob" {
+		t.Fatalf("aprobadores tras bob = %v, se esperaba [bob]", approvers)
+	}
+	if n := team.deployments(t); n != 0 {
+		t.Fatalf("la acción se ejecutó con una sola aprobación (%d veces)", n)
+	}
+
+	team.approve(t, "carol", id)
+	if n := team.deployments(t); n != 1 {
+		t.Fatalf("ejecuciones tras la segunda aprobación = %d, se esperaba 1", n)
+	}
+	if _, pending := team.approvers(id); pending {
+		t.Error("la solicitud sigue pendiente tras completarse")
+	}
+	// La acción consume el cooldown del solicitante, no el del último aprobador.
+	if _, exists := team.s.actionCooldowns[cooldownKey{"alice", "deploy"}]; !exists {
+		t.Error("la ejecución no registró el cooldown de alice")
+	}
+
+	// Una vez completada, la solicitud no admite más aprobaciones.
+	team.approve(t, "alice", id)
+	if n := team.deployments(t); n != 1 {
+		t.Errorf("una aprobación tardía volvió a ejecutar la acción (%d veces)", n)
+	}
+}
+
+// El solicitante recibe en el acuse 'pending' el ID que deben aprobar los demás.
+func TestApprovalRequestIsAcknowledged(t *testing.T) {
+	team := newApprovalTeam(t)
+	conn := team.listen(t, "alice")
+
+	payload := protocol.NewPayload("deploy")
+	packet := signKnock(t, team.keys["alice"], payload, team.ips["alice"])
+	packet.SourcePort = conn.LocalAddr().(*net.UDPAddr).Port
+	team.s.processKnock(packet)
+
+	ack := team.readAck(t, conn, payload.Nonce)
+	if ack.Status != protocol.AckPending || !strings.Contains(ack.Message, payload.Nonce) {
+		t.Errorf("acuse = %s %q, se esperaba %s con el ID %s", ack.Status, ack.Message, protocol.AckPending, payload.Nonce)
+	}
+	if _, pending := team.approvers(payload.Nonce); !pending {
+		t.Error("el ID del acuse no corresponde a ninguna solicitud pendiente")
+	}
+}
+
+func TestApprovalRejections(t *testing.T) {
+	team := newApprovalTeam(t)
+	id := team.request(t)
+
+	// El solicitante no cuenta, aunque figure entre los aprobadores.
+	team.approve(t, "alice", id)
+	// dave es un usuario válido, pero no puede aprobar "deploy".
+	team.approve(t, "dave", id)
+	// Aprobar dos veces no suma dos aprobaciones.
+	team.approve(t, "bob", id)
+	team.approve(t, "bob", id)
+	// Una solicitud que no existe no se crea al aprobarla.
+	unknown := protocol.NewPayload("deploy").Nonce
+	team.approve(t, "carol", unknown)
+
+	if approvers, pending := team.approvers(id); !pending || len(approvers) != 1 || approvers[0] != "bob" {
+		t.Errorf("aprobadores = %v (pendiente = %v), se esperaba solo [bob]", approvers, pending)
+	}
+	if _, pending := team.approvers(unknown); pending {
+		t.Error("aprobar una solicitud desconocida la dejó pendiente")
+	}
+	if n := team.deployments(t); n != 0 {
+		t.Errorf("la acción se ejecutó sin las aprobaciones requeridas (%d veces)", n)
+	}
+}
+
+// reload sustituye la configuración del equipo como lo haría un SIGHUP.
+func (team *approvalTeam) reload(t *testing.T, aliceActions string, required int, approvers string) {
+	t.Helper()
+	if err := os.WriteFile(team.s.configPath, []byte(team.config(aliceActions, required, approvers)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.s.reloadConfig(); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+}
+
+// Las aprobaciones no bastan si, mientras se reunían, el solicitante ha perdido
+// el permiso para la acción o un aprobador el de aprobarla.
+func TestApprovalRechecksParties(t *testing.T) {
+	team := newApprovalTeam(t)
+	id := team.request(t)
+	team.approve(t, "bob", id)
+	team.reload(t, `["status"]`, 2, `["alice", "bob", "carol"]`)
+	team.approve(t, "carol", id)
+	if n := team.deployments(t); n != 0 {
+		t.Errorf("la acción se ejecutó para un solicitante que ya no la tiene permitida (%d veces)", n)
+	}
+
+	team = newApprovalTeam(t)
+	id = team.request(t)
+	team.approve(t, "bob", id)
+	team.reload(t, `["deploy"]`, 2, `["alice", "carol", "dave"]`)
+	team.approve(t, "carol", id)
+	if n := team.deployments(t); n != 0 {
+		t.Errorf("contó la aprobación de bob, que ya no es aprobador (%d ejecuciones)", n)
+	}
+}
+
+// Una recarga puede exigir más aprobaciones a una solicitud pendiente, pero no menos.
+func TestApprovalKeepsRequiredFromRequest(t *testing.T) {
+	team := newApprovalTeam(t)
+	id := team.request(t)
+	team.reload(t, `["deploy"]`, 1, `["alice", "bob", "carol"]`)
+	team.approve(t, "bob", id)
+	if n := team.deployments(t); n != 0 {
+		t.Fatalf("bajar require_approvals completó la solicitud con una aprobación (%d ejecuciones)", n)
+	}
+
+	team.reload(t, `["deploy"]`, 3, `["alice", "bob", "carol", "dave"]`)
+	team.approve(t, "carol", id)
+	if n := team.deployments(t); n != 0 {
+		t.Fatalf("la solicitud se completó con 2 aprobaciones tras subir require_approvals a 3 (%d ejecuciones)", n)
+	}
+	team.approve(t, "dave", id)
+	if n := team.deployments(t); n != 1 {
+		t.Errorf("ejecuciones tras la tercera aprobación = %d, se esperaba 1", n)
+	}
+}
+
+func TestApprovalStoreLimitsPendingRequests(t *testing.T) {
+	store := newApprovalStore()
+	now := time.Now()
+	request := func(user, actionID string) *pendingApproval {
+		return &pendingApproval{
+			ID:        protocol.NewPayload(actionID).Nonce,
+			ActionID:  actionID,
+			Requester: approvalParty{Name: user},
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Minute),
+		}
+	}
+
+	for i := 0; i < maxPendingApprovalsPerUser; i++ {
+		if !store.add(request("alice", fmt.Sprintf("action-%d", i))) {
+			t.Fatalf("add() rechazó la solicitud %d de alice", i+1)
+		}
+	}
+	if store.add(request("alice", "otra")) {
+		t.Error("alice superó el máximo de solicitudes pendientes")
+	}
+
+	// El límite por acción cuenta las solicitudes de todos los usuarios.
+	for i := 0; i < maxPendingApprovalsPerAction; i++ {
+		if !store.add(request(fmt.Sprintf("user-%d", i), "deploy")) {
+			t.Fatalf("add() rechazó la solicitud %d de deploy", i+1)
+		}
+	}
+	if store.add(request("bob", "deploy")) {
+		t.Error("deploy superó el máximo de solicitudes pendientes")
+	}
+
+	// Las solicitudes caducadas dejan de contar aunque aún no se hayan purgado.
+	later := request("alice", "otra")
+	later.CreatedAt = now.Add(time.Minute)
+	later.ExpiresAt = later.CreatedAt.Add(time.Minute)
+	if !store.add(later) {
+		t.Error("las solicitudes caducadas de alice siguen contando para el máximo")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"log/slog"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/listener"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// certificateUser extrae y verifica el certificado de un sobre con
+// FlagCertificate y devuelve el usuario derivado de él, la clave con la que
+// verificar el sobre y el payload que sigue al certificado. Si el certificado
+// no es aceptable o está revocado, registra el descarte y devuelve ok=false.
+func (s *Server) certificateUser(cfg *config.Config, envelope *protocol.Envelope, packetInfo listener.PacketInfo) (user *config.User, publicKey ed25519.PublicKey, payload []byte, ok bool) {
+	drop := func(reason string, attrs ...any) {
+		attrs = append([]any{"reason", reason, "source_ip", packetInfo.SourceIP.String()}, attrs...)
+		slog.Warn("Paquete descartado", attrs...)
+		s.countDrop(reason, "", "")
+	}
+
+	certBytes, payload, err := protocol.SplitCertificate(envelope.Body)
+	if err != nil {
+		drop("invalid_certificate", "error", err)
+		return nil, nil, nil, false
+	}
+	cert, err := protocol.ParseCertificate(certBytes)
+	if err != nil {
+		drop("invalid_certificate", "error", err)
+		return nil, nil, nil, false
+	}
+
+	user, err = cfg.CertificateUser(cert)
+	if errors.Is(err, config.ErrUnknownCA) {
+		drop("unknown_ca", "subject", cert.Subject, "certificate_serial", cert.Serial, "ca_key_id", cert.CAKeyID)
+		return nil, nil, nil, false
+	}
+	if errors.Is(err, config.ErrSubjectConflict) {
+		drop("certificate_subject_conflict", "subject", cert.Subject, "certificate_serial", cert.Serial, "ca_key_id", cert.CAKeyID)
+		return nil, nil, nil, false
+	}
+	if err != nil {
+		drop("invalid_certificate", "subject", cert.Subject, "certificate_serial", cert.Serial, "error", err)
+		return nil, nil, nil, false
+	}
+
+	// El sobre debe ir firmado por la clave que certifica la CA.
+	publicKey = ed25519.PublicKey(cert.PublicKey)
+	if protocol.KeyIDFromPublicKey(publicKey) != envelope.KeyID {
+		drop("invalid_certificate", "subject", cert.Subject, "certificate_serial", cert.Serial, "error", "el identificador de clave del sobre no corresponde al certificado")
+		return nil, nil, nil, false
+	}
+
+	if s.certificateRevoked(cfg, cert) {
+		drop("revoked_certificate", "subject", cert.Subject, "certificate_serial", cert.Serial, "ca_key_id", cert.CAKeyID)
+		return nil, nil, nil, false
+	}
+
+	slog.Debug("Certificado verificado", "subject", cert.Subject, "certificate_serial", cert.Serial, "ca_key_id", cert.CAKeyID, "not_after", cert.ValidUntil())
+	return user, publicKey, payload, true
+}
+
+// certificateRevoked indica si la lista de revocación incluye el certificado
+// (ya verificado): su número de serie, la clave certificada o la de su CA.
+func (s *Server) certificateRevoked(cfg *config.Config, cert *protocol.Certificate) bool {
+	caKeyID, err := cert.CAKey()
+	if err != nil {
+		return false
+	}
+	return s.revoked.ContainsCertificate(cert, cfg.Server.CAKeys[caKeyID])
+}
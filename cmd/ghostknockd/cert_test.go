@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/listener"
+	"github.com/your-org/ghostknock/internal/protocol"
+	"github.com/your-org/ghostknock/internal/revocation"
+)
+
+func TestCertificateRevocation(t *testing.T) {
+	alicePublicKey, _ := generateKey(t)
+	caPublicKey, caPrivateKey := generateKey(t)
+	holderPublicKey, holderPrivateKey := generateKey(t)
+	s := newTestServer(t, "server:\n  trusted_ca_keys: [\""+base64.StdEncoding.EncodeToString(caPublicKey)+"\"]\n"+
+		testConfig(alicePublicKey, openSSHAction))
+
+	signed, err := protocol.NewCertificate("ci@build", holderPublicKey, []string{"open-ssh"}, nil, time.Now().Add(-time.Minute), time.Hour).Sign(caPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := protocol.ParseCertificate(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := protocol.AttachCertificate(signed, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := protocol.NewEnvelope(body, protocol.FlagCertificate, holderPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetInfo := listener.PacketInfo{SourceIP: aliceIP, SourcePort: 40000}
+	party := approvalParty{Name: cert.Subject, Key: holderPublicKey, Cert: cert, SourceIP: aliceIP}
+
+	revocationFile := filepath.Join(t.TempDir(), "revoked")
+	revoke := func(entry string) {
+		t.Helper()
+		if err := os.WriteFile(revocationFile, []byte(entry+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if s.revoked, err = revocation.NewWatcher(revocationFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revoke("# sin entradas")
+	if _, _, _, ok := s.certificateUser(s.currentConfig(), envelope, packetInfo); !ok {
+		t.Fatal("se descartó un certificado válido sin revocar")
+	}
+	if reason := s.recheckParty(s.currentConfig(), party, "open-ssh", false, time.Now()); reason != "" {
+		t.Fatalf("recheckParty = %q para un certificado sin revocar", reason)
+	}
+
+	// Un certificado se revoca por su número de serie, por la clave que certifica
+	// o por la clave de la CA que lo firmó, tanto al recibir el knock como al
+	// volver a autorizar una solicitud de aprobación pendiente.
+	caKeyID := protocol.KeyIDFromPublicKey(caPublicKey)
+	for _, entry := range []string{
+		"serial:" + cert.Serial,
+		base64.StdEncoding.EncodeToString(holderPublicKey),
+		hex.EncodeToString(caKeyID[:]),
+	} {
+		revoke(entry)
+		if _, _, _, ok := s.certificateUser(s.currentConfig(), envelope, packetInfo); ok {
+			t.Errorf("%s: se aceptó un certificado revocado", entry)
+		}
+		if reason := s.recheckParty(s.currentConfig(), party, "open-ssh", false, time.Now()); reason != "revoked_certificate" {
+			t.Errorf("%s: recheckParty = %q, se esperaba revoked_certificate", entry, reason)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// signatureCache recuerda las firmas de los knocks ya verificados durante la
+// ventana anti-replay. Permite descartar sin coste las repeticiones que envía el
+// cliente con -repeat antes del rate limiting, de modo que no cuentan como abuso
+// ni llegan a la caché de nonces o al cooldown. Solo se registran firmas
+// verificadas, así que un tercero no puede usarla para silenciar knocks legítimos.
+type signatureCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	seen    map[string]time.Time // firma -> instante de verificación
+}
+
+func newSignatureCache(ttl time.Duration, maxSize int) *signatureCache {
+	return &signatureCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// contains indica si la firma pertenece a un knock verificado dentro de la ventana.
+func (c *signatureCache) contains(signature []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seenAt, ok := c.seen[string(signature)]
+	return ok && time.Since(seenAt) <= c.ttl
+}
+
+// add registra la firma de un knock verificado. Si la caché está llena, la
+// repetición simplemente seguirá el camino normal y la rechazará su nonce.
+func (c *signatureCache) add(signature []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.seen) >= c.maxSize {
+		c.purgeLocked(time.Now())
+		if len(c.seen) >= c.maxSize {
+			return
+		}
+	}
+	c.seen[string(signature)] = time.Now()
+}
+
+// purge elimina las firmas cuya ventana ya ha expirado y devuelve cuántas se borraron.
+func (c *signatureCache) purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.purgeLocked(time.Now())
+}
+
+func (c *signatureCache) purgeLocked(now time.Time) int {
+	purgedCount := 0
+	for signature, seenAt := range c.seen {
+		if now.Sub(seenAt) > c.ttl {
+			delete(c.seen, signature)
+			purgedCount++
+		}
+	}
+	return purgedCount
+}
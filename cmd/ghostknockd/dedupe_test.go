@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+func TestSignatureCache(t *testing.T) {
+	const ttl = time.Minute
+	cache := newSignatureCache(ttl, 2)
+
+	if cache.contains([]byte("a")) {
+		t.Fatal("una firma nunca vista figura en la caché")
+	}
+	cache.add([]byte("a"))
+	if !cache.contains([]byte("a")) {
+		t.Fatal("la firma registrada no figura en la caché")
+	}
+
+	// Con la caché llena, las firmas nuevas no se registran: la repetición
+	// seguirá el camino normal y la rechazará su nonce.
+	cache.add([]byte("b"))
+	cache.add([]byte("c"))
+	if cache.contains([]byte("c")) {
+		t.Error("la caché superó su tamaño máximo")
+	}
+
+	cache.seen["a"] = time.Now().Add(-ttl - time.Second)
+	if cache.contains([]byte("a")) {
+		t.Error("una firma fuera de la ventana sigue figurando en la caché")
+	}
+	if purged := cache.purge(); purged != 1 {
+		t.Errorf("purge() = %d, se esperaba 1", purged)
+	}
+}
+
+// Las copias de un knock enviadas con -repeat no ejecutan la acción otra vez ni
+// consumen el cupo de rate limiting de la IP.
+func TestRepeatedKnockIsDeduplicated(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "executions")
+	publicKey, privateKey := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, `  "open-ssh":
+    command: "echo {{.Params.n}} >> `+log+`"
+    cooldown_seconds: 0
+`))
+
+	first := protocol.NewPayload("open-ssh")
+	first.Params["n"] = "first"
+	packet := signKnock(t, privateKey, first, aliceIP)
+	for i := 0; i < 2*rateLimitBurst; i++ {
+		s.processKnock(packet)
+	}
+
+	second := protocol.NewPayload("open-ssh")
+	second.Params["n"] = "second"
+	s.processKnock(signKnock(t, privateKey, second, aliceIP))
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Fatalf("ejecuciones = %q, se esperaba [first second]", got)
+	}
+}
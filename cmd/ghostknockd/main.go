@@ -4,9 +4,8 @@ package main
 import (
 	"context"
 	"crypto/ed25519"
+	"encoding/hex"
 	"flag"
-	"fmt"
-	"log"
 	"log/slog"
 	"net"
 	"os"
@@ -16,10 +15,15 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/your-org/ghostknock/internal/audit"
 	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/control"
 	"github.com/your-org/ghostknock/internal/executor"
 	"github.com/your-org/ghostknock/internal/listener"
+	"github.com/your-org/ghostknock/internal/logging"
+	"github.com/your-org/ghostknock/internal/metrics"
 	"github.com/your-org/ghostknock/internal/protocol"
+	"github.com/your-org/ghostknock/internal/revocation"
 	"golang.org/x/time/rate"
 )
 
@@ -31,7 +35,12 @@ const (
 	rateLimitBurst           = 3
 	limiterCleanupInterval   = 3 * time.Minute
 	limiterEvictionAge       = 5 * time.Minute
-	logFilePath              = "/var/log/ghostknockd.log"
+	maxNoncesPerKey          = 1024
+	maxRecentSignatures      = 4096
+	// Las solicitudes de aprobación viven en memoria hasta completarse o caducar;
+	// cada solicitante y cada acción solo pueden tener estas pendientes a la vez.
+	maxPendingApprovalsPerUser   = 4
+	maxPendingApprovalsPerAction = 16
 )
 
 type ipLimiter struct {
@@ -40,11 +49,25 @@ type ipLimiter struct {
 }
 
 type Server struct {
+	configPath      string
 	config          *config.Config
-	actionCooldowns map[string]time.Time
+	configMutex     sync.RWMutex
+	logLevel        *slog.LevelVar
+	actionCooldowns map[cooldownKey]time.Time
 	cacheMutex      sync.RWMutex
 	ipLimiters      map[string]*ipLimiter
 	limitersMutex   sync.Mutex
+	seenNonces      *nonceCache
+	seenSignatures  *signatureCache
+	reverts         *executor.Scheduler
+	approvals       *approvalStore
+	revoked         *revocation.Watcher
+	startedAt       time.Time
+	configLoadedAt  time.Time
+	// reloadRequests lleva al bucle principal las recargas pedidas por el socket de control.
+	reloadRequests chan chan error
+	// stopped se cierra al terminar el bucle principal, que ya no atenderá recargas.
+	stopped chan struct{}
 }
 
 func main() {
@@ -58,32 +81,36 @@ func main() {
 		os.Exit(1)
 	}
 
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		log.Fatalf("FATAL: No se pudo abrir el archivo de log en %s: %v. ¿Ejecutaste con sudo?", logFilePath, err)
+	// El nivel se guarda en un LevelVar para poder cambiarlo al recargar la configuración.
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(parseLogLevel(cfg.Logging.LogLevel))
+
+	// Si el destino configurado no está disponible (ej. /var/log de solo lectura
+	// bajo ProtectSystem), el demonio sigue funcionando con el log en stderr.
+	logHandler, logCloser, logErr := logging.New(cfg.Logging, logLevel)
+	if logErr != nil {
+		logHandler = logging.Fallback(logLevel)
+	} else {
+		defer logCloser.Close()
 	}
-	defer logFile.Close()
-
-	var logLevel slog.Level
-	switch cfg.Logging.LogLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo
+	slog.SetDefault(slog.New(logHandler))
+	if logErr != nil {
+		slog.Warn("Destino de log no disponible, se usará stderr", "output", cfg.Logging.Output, "error", logErr)
 	}
 
-	handlerOpts := &slog.HandlerOptions{Level: logLevel}
-	logger := slog.New(slog.NewTextHandler(logFile, handlerOpts))
-	slog.SetDefault(logger)
-
 	slog.Info("Iniciando demonio GhostKnockd...")
 
+	if cfg.Logging.AuditFile != "" {
+		auditLog, err := audit.Open(cfg.Logging.AuditFile)
+		if err != nil {
+			slog.Error("No se pudo abrir el registro de auditoría", "path", cfg.Logging.AuditFile, "error", err)
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		audit.SetDefault(auditLog)
+		slog.Info("Registro de auditoría activado", "path", cfg.Logging.AuditFile)
+	}
+
 	if cfg.Daemon.PIDFile != "" {
 		pid := os.Getpid()
 		pidStr := strconv.Itoa(pid)
@@ -108,24 +135,87 @@ func main() {
 		"actions_count", len(cfg.Actions),
 		"log_level", cfg.Logging.LogLevel,
 	)
+	warnExpiringKeys(cfg)
 
 	server := &Server{
+		configPath:      *configFile,
 		config:          cfg,
-		actionCooldowns: make(map[string]time.Time),
+		logLevel:        logLevel,
+		actionCooldowns: make(map[cooldownKey]time.Time),
 		ipLimiters:      make(map[string]*ipLimiter),
+		seenNonces:      newNonceCache(replayWindowSeconds*time.Second, maxNoncesPerKey),
+		seenSignatures:  newSignatureCache(replayWindowSeconds*time.Second, maxRecentSignatures),
+		reverts:         executor.NewScheduler(cfg.Daemon.RevertJournal),
+		approvals:       newApprovalStore(),
+		startedAt:       time.Now(),
+		configLoadedAt:  time.Now(),
+		reloadRequests:  make(chan chan error),
+		stopped:         make(chan struct{}),
+	}
+
+	if cfg.Daemon.RevocationFile != "" {
+		server.revoked, err = revocation.NewWatcher(cfg.Daemon.RevocationFile)
+		if err != nil {
+			slog.Error("No se pudo cargar el archivo de revocación", "path", cfg.Daemon.RevocationFile, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Reanudar las reversiones que quedaron pendientes antes de un reinicio o caída.
+	if err := server.reverts.Restore(); err != nil {
+		slog.Error("No se pudo restaurar el diario de reversiones", "path", cfg.Daemon.RevertJournal, "error", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go server.startCacheCleaner()
 	go server.startLimiterCleaner()
+	if server.revoked != nil {
+		go server.revoked.Run(ctx.Done())
+	}
 
+	// El listener tiene su propio contexto para poder reiniciarlo si una recarga cambia su sección.
+	listenerCtx, stopListener := context.WithCancel(ctx)
 	packetsCh := make(chan listener.PacketInfo)
-	go listener.Start(ctx, cfg.Listener, packetsCh)
+	go listener.Start(listenerCtx, cfg.Listener, packetsCh)
+
+	if cfg.Metrics.Enabled {
+		go func() {
+			if err := metrics.Serve(ctx, cfg.Metrics.Listen, cfg.Metrics.Path); err != nil {
+				slog.Error("El endpoint de métricas no está disponible", "listen", cfg.Metrics.Listen, "error", err)
+			}
+		}()
+	}
+
+	go func() {
+		if err := control.Serve(ctx, cfg.Daemon.ControlSocket, server); err != nil {
+			slog.Error("El socket de control no está disponible", "path", cfg.Daemon.ControlSocket, "error", err)
+		}
+	}()
+
+	// reload aplica una recarga desde el bucle principal, único dueño del listener.
+	reload := func() error {
+		oldCfg := server.currentConfig()
+		newCfg, err := server.reloadConfig()
+		if err != nil {
+			return err
+		}
+		if newCfg.Listener != oldCfg.Listener {
+			slog.Info("La sección 'listener' ha cambiado, reiniciando la captura de paquetes")
+			stopListener()
+			// Vaciamos el canal hasta que el listener anterior lo cierre.
+			for range packetsCh {
+			}
+			listenerCtx, stopListener = context.WithCancel(ctx)
+			packetsCh = make(chan listener.PacketInfo)
+			go listener.Start(listenerCtx, newCfg.Listener, packetsCh)
+		}
+		return nil
+	}
 
 	slog.Info("El listener está activo, procesando knocks y esperando señales...")
 
@@ -138,16 +228,130 @@ mainLoop:
 				break mainLoop
 			}
 			server.processKnock(packetInfo)
+		case reply := <-server.reloadRequests:
+			reply <- reload()
 		case sig := <-signalChan:
+			if sig == syscall.SIGHUP {
+				_ = reload() // Los errores ya quedan registrados por reloadConfig.
+				continue
+			}
 			slog.Info("Señal de apagado recibida", "signal", sig.String())
 			slog.Info("Iniciando cierre controlado...")
 			cancel()
 		}
 	}
 
+	close(server.stopped)
+	stopListener()
 	slog.Info("Demonio GhostKnockd detenido limpiamente.")
 }
 
+// parseLogLevel traduce el nivel de log de la configuración a un slog.Level.
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// currentConfig devuelve la configuración activa. Cada knock trabaja sobre una
+// única instantánea, por lo que una recarga nunca le afecta a mitad de proceso.
+func (s *Server) currentConfig() *config.Config {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.config
+}
+
+// reloadConfig vuelve a leer y validar el archivo de configuración. Si es válido,
+// sustituye la configuración activa de forma atómica; si no, conserva la anterior
+// y registra el error. Las reversiones pendientes, los cooldowns y los limitadores
+// de IP no se ven afectados.
+func (s *Server) reloadConfig() (*config.Config, error) {
+	slog.Info("Recargando configuración", "file", s.configPath)
+
+	newCfg, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		slog.Error("Recarga descartada: la nueva configuración no es válida, se mantiene la anterior", "file", s.configPath, "error", err)
+		return nil, err
+	}
+
+	s.configMutex.Lock()
+	oldCfg := s.config
+	s.config = newCfg
+	s.configLoadedAt = time.Now()
+	s.configMutex.Unlock()
+
+	s.logLevel.Set(parseLogLevel(newCfg.Logging.LogLevel))
+	reopenAuditLog(newCfg.Logging.AuditFile)
+	if daemonRestartFields(newCfg.Daemon) != daemonRestartFields(oldCfg.Daemon) {
+		slog.Warn("Los cambios en la sección 'daemon' requieren reiniciar el demonio para aplicarse")
+	}
+	if logDestination(newCfg.Logging) != logDestination(oldCfg.Logging) {
+		slog.Warn("Los cambios en el destino o formato del log requieren reiniciar el demonio para aplicarse")
+	}
+	if newCfg.Metrics != oldCfg.Metrics {
+		slog.Warn("Los cambios en la sección 'metrics' requieren reiniciar el demonio para aplicarse")
+	}
+
+	slog.Info(
+		"Configuración recargada con éxito",
+		"users_count", len(newCfg.Users),
+		"actions_count", len(newCfg.Actions),
+		"log_level", newCfg.Logging.LogLevel,
+	)
+	warnExpiringKeys(newCfg)
+	return newCfg, nil
+}
+
+// daemonRestartFields devuelve la parte de la sección 'daemon' que no se aplica en caliente.
+func daemonRestartFields(d config.Daemon) config.Daemon {
+	d.KeyExpiryWarningDays = 0
+	return d
+}
+
+// logDestination devuelve la parte de la sección 'logging' que no se aplica en caliente.
+func logDestination(l config.Logging) config.Logging {
+	l.LogLevel = ""
+	l.AuditFile = ""
+	return l
+}
+
+// reopenAuditLog aplica la sección de auditoría tras una recarga. Si la ruta no
+// cambia, el archivo se reabre para que logrotate pueda rotarlo con un SIGHUP.
+func reopenAuditLog(path string) {
+	current := audit.Default()
+	if current != nil && current.Path() == path {
+		if err := current.Reopen(); err != nil {
+			slog.Error("No se pudo reabrir el registro de auditoría", "path", path, "error", err)
+		}
+		return
+	}
+
+	if path == "" {
+		audit.SetDefault(nil)
+	} else {
+		auditLog, err := audit.Open(path)
+		if err != nil {
+			// Se conserva el registro anterior para no perder eventos.
+			slog.Error("No se pudo abrir el nuevo registro de auditoría, se mantiene el anterior", "path", path, "error", err)
+			return
+		}
+		audit.SetDefault(auditLog)
+		slog.Info("Registro de auditoría activado", "path", path)
+	}
+	if current != nil {
+		current.Close()
+	}
+}
+
 func (s *Server) getLimiter(ip net.IP) *rate.Limiter {
 	s.limitersMutex.Lock()
 	defer s.limitersMutex.Unlock()
@@ -198,45 +402,113 @@ func (s *Server) startCacheCleaner() {
 		if purgedCount > 0 {
 			slog.Debug("Limpiadas entradas de cooldown antiguas", "count", purgedCount)
 		}
+
+		if purgedNonces := s.seenNonces.purge(); purgedNonces > 0 {
+			slog.Debug("Limpiados nonces fuera de la ventana anti-replay", "count", purgedNonces)
+		}
+		if purgedSignatures := s.seenSignatures.purge(); purgedSignatures > 0 {
+			slog.Debug("Limpiadas firmas de knocks repetidos", "count", purgedSignatures)
+		}
+
+		s.expireApprovals()
 	}
 }
 
 func (s *Server) processKnock(packetInfo listener.PacketInfo) {
-	// 1. RATE LIMITING
+	// 1. REPETICIONES Y RATE LIMITING
+	// Las copias de un knock ya verificado (enviadas con -repeat) se descartan antes
+	// del rate limiting para que no consuman el cupo de la IP. El análisis del sobre
+	// no realiza ninguna operación criptográfica.
+	envelope, envelopeErr := protocol.Unmarshal(packetInfo.Payload)
+	if envelopeErr == nil && s.seenSignatures.contains(envelope.Signature) {
+		slog.Debug("Paquete descartado", "reason", "duplicate_knock", "source_ip", packetInfo.SourceIP.String())
+		s.countDrop("duplicate_knock", "", "")
+		return
+	}
+
 	limiter := s.getLimiter(packetInfo.SourceIP)
 	if !limiter.Allow() {
 		slog.Warn("Paquete descartado", "reason", "rate_limit_exceeded", "source_ip", packetInfo.SourceIP.String())
+		s.countDrop("rate_limit_exceeded", "", "")
 		return
 	}
 
 	// 2. VALIDACIÓN DE ESTRUCTURA BÁSICA
-	rawPayload := packetInfo.Payload
-	if len(rawPayload) <= ed25519.SignatureSize {
+	// El ruido UDP ajeno o de versiones desconocidas se descarta aquí, antes de cualquier operación criptográfica.
+	if envelopeErr != nil {
+		slog.Debug("Paquete descartado", "reason", "malformed_envelope", "source_ip", packetInfo.SourceIP.String(), "error", envelopeErr)
+		s.countDrop("malformed_envelope", "", "")
 		return
 	}
+	serializedPayload := envelope.Body
 
-	signature := rawPayload[:ed25519.SignatureSize]
-	serializedPayload := rawPayload[ed25519.SignatureSize:]
+	cfg := s.currentConfig()
 
 	// 3. VERIFICACIÓN CRIPTOGRÁFICA TEMPRANA
+	// El identificador de clave del sobre selecciona al único candidato; solo se verifica una firma por paquete.
+	// Con FlagCertificate, el usuario y su clave se derivan del certificado firmado por una CA de confianza.
 	var authorizedUser *config.User
-	for i := range s.config.Users {
-		user := &s.config.Users[i]
-		if ed25519.Verify(user.DecodedPublicKey, serializedPayload, signature) {
-			authorizedUser = user
-			break
+	var publicKey ed25519.PublicKey
+	if envelope.Flags&protocol.FlagCertificate != 0 {
+		var ok bool
+		authorizedUser, publicKey, serializedPayload, ok = s.certificateUser(cfg, envelope, packetInfo)
+		if !ok {
+			return
 		}
+	} else {
+		authorizedUser, publicKey = cfg.UserByKeyID(envelope.KeyID)
+		if authorizedUser == nil {
+			slog.Warn("Paquete descartado", "reason", "unknown_key_id", "source_ip", packetInfo.SourceIP.String(), "key_id", hex.EncodeToString(envelope.KeyID[:]))
+			s.countDrop("unknown_key_id", "", "")
+			return
+		}
+	}
+
+	if !envelope.Verify(publicKey) {
+		slog.Warn("Paquete descartado", "reason", "invalid_signature", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name)
+		s.countDrop("invalid_signature", authorizedUser.Name, "")
+		return
+	}
+	s.seenSignatures.add(envelope.Signature)
+
+	// Las claves revocadas se descartan antes de descifrar o evaluar cualquier acción.
+	if s.revoked.Contains(publicKey) {
+		slog.Warn("Paquete descartado", "reason", "revoked_key", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "key_id", hex.EncodeToString(envelope.KeyID[:]))
+		s.countDrop("revoked_key", authorizedUser.Name, "")
+		return
+	}
+
+	// Las claves fuera de su periodo de validez se descartan antes de descifrar nada.
+	if !authorizedUser.Validity.Contains(time.Now()) {
+		logExpiredKey(packetInfo.SourceIP, authorizedUser.Name, "", authorizedUser.Validity)
+		s.countDrop("expired_key", authorizedUser.Name, "")
+		return
 	}
 
-	if authorizedUser == nil {
-		slog.Warn("Paquete descartado", "reason", "invalid_signature", "source_ip", packetInfo.SourceIP.String())
+	// 4. DESCIFRADO Y DESERIALIZACIÓN SEGURA (Solo si la firma es válida)
+	if envelope.Flags&protocol.FlagSealed != 0 {
+		if cfg.Server.SealingKey == nil {
+			slog.Warn("Paquete descartado", "reason", "sealing_not_configured", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name)
+			s.countDrop("sealing_not_configured", authorizedUser.Name, "")
+			return
+		}
+		var err error
+		serializedPayload, err = protocol.Open(cfg.Server.SealingKey, serializedPayload)
+		if err != nil {
+			slog.Warn("Paquete descartado", "reason", "decryption_failed", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "error", err)
+			s.countDrop("decryption_failed", authorizedUser.Name, "")
+			return
+		}
+	} else if cfg.Server.RequireSealed {
+		slog.Warn("Paquete descartado", "reason", "unsealed_payload", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name)
+		s.countDrop("unsealed_payload", authorizedUser.Name, "")
 		return
 	}
 
-	// 4. DESERIALIZACIÓN SEGURA (Solo si la firma es válida)
 	payload, err := protocol.DeserializePayload(serializedPayload)
 	if err != nil {
 		slog.Warn("Paquete descartado", "reason", "payload_deserialization_failed", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "error", err)
+		s.countDrop("payload_deserialization_failed", authorizedUser.Name, "")
 		return
 	}
 
@@ -245,71 +517,111 @@ func (s *Server) processKnock(packetInfo listener.PacketInfo) {
 	age := time.Since(timestamp)
 	if age < 0 || age > (replayWindowSeconds*time.Second) {
 		slog.Warn("Paquete descartado", "reason", "outside_replay_window", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "age_seconds", age.Seconds())
+		s.countDrop("outside_replay_window", authorizedUser.Name, payload.ActionID)
 		return
 	}
 
-	if !isActionAllowed(payload.ActionID, authorizedUser.AllowedActions) {
-		slog.Warn("Paquete descartado", "reason", "unauthorized_action", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "action_id", payload.ActionID)
+	switch s.seenNonces.checkAndStore(hex.EncodeToString(envelope.KeyID[:]), payload.Nonce) {
+	case nonceReplayed:
+		slog.Warn("Paquete descartado", "reason", "replayed_nonce", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "nonce", payload.Nonce)
+		s.countDrop("replayed_nonce", authorizedUser.Name, payload.ActionID)
+		return
+	case nonceCacheFull:
+		slog.Warn("Paquete descartado", "reason", "replay_cache_full", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name)
+		s.countDrop("replay_cache_full", authorizedUser.Name, payload.ActionID)
 		return
 	}
 
-	if len(authorizedUser.SourceCIDRs) > 0 {
-		isIPAllowed := false
-		for _, cidr := range authorizedUser.SourceCIDRs {
-			if cidr.Contains(packetInfo.SourceIP) {
-				isIPAllowed = true
-				break
-			}
+	// Aprobar una acción no requiere poder ejecutarla, sino figurar entre sus aprobadores.
+	if payload.Verb == protocol.VerbApprove {
+		if !cfg.Actions[payload.ActionID].IsApprover(authorizedUser.Name) {
+			slog.Warn("Paquete descartado", "reason", "unauthorized_approver", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "action_id", payload.ActionID)
+			s.countDrop("unauthorized_approver", authorizedUser.Name, payload.ActionID)
+			return
 		}
-		if !isIPAllowed {
+	} else if !isActionAllowed(payload.ActionID, authorizedUser.AllowedActions) {
+		slog.Warn("Paquete descartado", "reason", "unauthorized_action", "source_ip", packetInfo.SourceIP.String(), "user", authorizedUser.Name, "action_id", payload.ActionID)
+		s.countDrop("unauthorized_action", authorizedUser.Name, payload.ActionID)
+		return
+	}
+
+	if validity := authorizedUser.ActionValidity[payload.ActionID]; !validity.Contains(time.Now()) {
+		logExpiredKey(packetInfo.SourceIP, authorizedUser.Name, payload.ActionID, validity)
+		s.countDrop("expired_key", authorizedUser.Name, payload.ActionID)
+		return
+	}
+
+	// Cerrar antes una acción (-revert-now) siempre está permitido, también fuera de horario.
+	if payload.Verb != protocol.VerbRevertNow {
+		if scope, schedule := outsideSchedule(authorizedUser, cfg.Actions[payload.ActionID], time.Now()); schedule != nil {
 			slog.Warn("Paquete descartado",
-				"reason", "unauthorized_source_ip",
+				"reason", "outside_schedule",
+				"source_ip", packetInfo.SourceIP.String(),
 				"user", authorizedUser.Name,
 				"action_id", payload.ActionID,
-				"source_ip", packetInfo.SourceIP.String(),
+				"schedule_scope", scope,
+				"schedule", schedule.String(),
 			)
+			s.countDrop("outside_schedule", authorizedUser.Name, payload.ActionID)
 			return
 		}
 	}
 
-	// 6. LÓGICA DE COOLDOWN
-	actionDef, ok := s.config.Actions[payload.ActionID]
+	if !isSourceAllowed(authorizedUser, packetInfo.SourceIP) {
+		slog.Warn("Paquete descartado",
+			"reason", "unauthorized_source_ip",
+			"user", authorizedUser.Name,
+			"action_id", payload.ActionID,
+			"source_ip", packetInfo.SourceIP.String(),
+		)
+		s.countDrop("unauthorized_source_ip", authorizedUser.Name, payload.ActionID)
+		return
+	}
+
+	actionDef, ok := cfg.Actions[payload.ActionID]
 	if !ok {
 		slog.Error("Inconsistencia de configuración: la acción autorizada no existe", "action_id", payload.ActionID)
 		return
 	}
 
-	effectiveCooldown := time.Duration(actionCooldownSeconds) * time.Second
-	if actionDef.CooldownSeconds >= 0 {
-		effectiveCooldown = time.Duration(actionDef.CooldownSeconds) * time.Second
+	if payload.Verb == protocol.VerbApprove {
+		recordAccepted(authorizedUser, envelope, payload, packetInfo.SourceIP)
+		s.handleApproval(cfg, authorizedUser, publicKey, actionDef, payload, packetInfo)
+		return
 	}
 
-	cooldownKey := fmt.Sprintf("%s:%s", authorizedUser.PublicKeyB64, payload.ActionID)
-
-	if effectiveCooldown > 0 {
-		s.cacheMutex.RLock()
-		lastExecutionTime, onCooldown := s.actionCooldowns[cooldownKey]
-		s.cacheMutex.RUnlock()
-
-		if onCooldown {
-			elapsed := time.Since(lastExecutionTime)
-			if elapsed < effectiveCooldown {
-				remaining := effectiveCooldown - elapsed
-				slog.Warn(
-					"Acción descartada",
-					"reason", "cooldown_active",
-					"user", authorizedUser.Name,
-					"action_id", payload.ActionID,
-					"remaining_seconds", remaining.Seconds(),
-				)
-				return
-			}
+	// Los verbos de control actúan sobre una ejecución previa; no ejecutan nada ni consumen cooldown.
+	if payload.Verb != protocol.VerbExecute {
+		recordAccepted(authorizedUser, envelope, payload, packetInfo.SourceIP)
+		status := protocol.AckAccepted
+		if !s.handleControlVerb(authorizedUser, actionDef, payload, packetInfo.SourceIP) {
+			status = protocol.AckRejected
 		}
+		sendAck(cfg, actionDef, packetInfo, protocol.NewAck(payload.Nonce, status))
+		return
 	}
 
-	s.cacheMutex.Lock()
-	s.actionCooldowns[cooldownKey] = time.Now()
-	s.cacheMutex.Unlock()
+	// 6. LÓGICA DE COOLDOWN
+	if remaining, onCooldown := s.cooldownRemaining(authorizedUser.Name, payload.ActionID, actionDef); onCooldown {
+		slog.Warn(
+			"Acción descartada",
+			"reason", "cooldown_active",
+			"user", authorizedUser.Name,
+			"action_id", payload.ActionID,
+			"remaining_seconds", remaining.Seconds(),
+		)
+		s.countDrop("cooldown_active", authorizedUser.Name, payload.ActionID)
+		sendAck(cfg, actionDef, packetInfo, protocol.NewAck(payload.Nonce, protocol.AckCooldown))
+		return
+	}
+
+	recordAccepted(authorizedUser, envelope, payload, packetInfo.SourceIP)
+
+	// Las acciones con 'require_approvals' esperan a que otros usuarios las aprueben.
+	if actionDef.RequireApprovals > 0 {
+		s.requestApproval(cfg, authorizedUser, publicKey, actionDef, payload, packetInfo)
+		return
+	}
 
 	slog.Info("Knock válido recibido y autorizado",
 		"user", authorizedUser.Name,
@@ -318,12 +630,163 @@ func (s *Server) processKnock(packetInfo listener.PacketInfo) {
 	)
 
 	// 7. EJECUCIÓN CON PARÁMETROS
+	s.executeAction(cfg, actionDef, authorizedUser.Name, payload, packetInfo.SourceIP, payload, packetInfo)
+}
+
+// cooldownRemaining indica si la acción está en cooldown para el usuario y
+// cuánto le queda.
+func (s *Server) cooldownRemaining(userName, actionID string, action config.Action) (time.Duration, bool) {
+	effectiveCooldown := effectiveCooldown(action)
+	if effectiveCooldown <= 0 {
+		return 0, false
+	}
+
+	s.cacheMutex.RLock()
+	lastExecutionTime, onCooldown := s.actionCooldowns[cooldownKey{userName, actionID}]
+	s.cacheMutex.RUnlock()
+
+	if !onCooldown {
+		return 0, false
+	}
+	elapsed := time.Since(lastExecutionTime)
+	if elapsed >= effectiveCooldown {
+		return 0, false
+	}
+	return effectiveCooldown - elapsed, true
+}
+
+// executeAction ejecuta la acción solicitada en request por userName desde
+// sourceIP y responde a replyTo, el knock que la desencadena: el propio
+// knock o la última aprobación.
+func (s *Server) executeAction(cfg *config.Config, action config.Action, userName string, request *protocol.Payload, sourceIP net.IP, reply *protocol.Payload, replyTo listener.PacketInfo) {
+	s.cacheMutex.Lock()
+	s.actionCooldowns[cooldownKey{userName, request.ActionID}] = time.Now()
+	s.cacheMutex.Unlock()
+
+	metrics.KnocksAccepted.WithLabelValues(userName, request.ActionID).Inc()
+
 	// Aquí es donde se pasan los params deserializados al ejecutor seguro.
-	if err := executor.Execute(actionDef, packetInfo.SourceIP, payload.Params); err != nil {
-		slog.Error("Falló la ejecución de la acción", "action_id", payload.ActionID, "user", authorizedUser.Name, "error", err)
+	req := executor.Request{
+		ActionID: request.ActionID,
+		User:     userName,
+		Action:   action,
+		SourceIP: sourceIP,
+		Params:   request.Params,
+	}
+	result, err := executor.Execute(req, s.reverts)
+	if err != nil {
+		slog.Error("Falló la ejecución de la acción", "action_id", request.ActionID, "user", userName, "error", err)
+	}
+	output := sealResultOutput(cfg, action, reply, result)
+	sendResponse(cfg, action, replyTo, ackForResult(reply.Nonce, result, err), output)
+}
+
+// recordAccepted deja constancia de un knock aceptado en el registro de auditoría.
+func recordAccepted(user *config.User, envelope *protocol.Envelope, payload *protocol.Payload, sourceIP net.IP) {
+	audit.Record(audit.Event{
+		Event:    audit.EventKnockAccepted,
+		User:     user.Name,
+		ActionID: payload.ActionID,
+		SourceIP: sourceIP.String(),
+		KeyID:    hex.EncodeToString(envelope.KeyID[:]),
+		Nonce:    payload.Nonce,
+		Verb:     payload.Verb,
+		Params:   payload.Params,
+	})
+}
+
+// logExpiredKey registra un knock descartado por estar fuera del periodo de validez.
+func logExpiredKey(sourceIP net.IP, user, actionID string, validity config.Validity) {
+	attrs := []any{"reason", "expired_key", "source_ip", sourceIP.String(), "user", user}
+	if actionID != "" {
+		attrs = append(attrs, "action_id", actionID)
+	}
+	if !validity.NotBefore.IsZero() {
+		attrs = append(attrs, "not_before", validity.NotBefore)
+	}
+	if !validity.NotAfter.IsZero() {
+		attrs = append(attrs, "not_after", validity.NotAfter)
+	}
+	slog.Warn("Paquete descartado", attrs...)
+}
+
+// outsideSchedule devuelve la franja horaria, del usuario o de la acción, que
+// no permite un knock en el instante now, o nil si ambas lo permiten.
+func outsideSchedule(user *config.User, action config.Action, now time.Time) (string, *config.Schedule) {
+	if !user.AllowedSchedule.Allows(now) {
+		return "user", user.AllowedSchedule
+	}
+	if !action.AllowedSchedule.Allows(now) {
+		return "action", action.AllowedSchedule
+	}
+	return "", nil
+}
+
+// warnExpiringKeys avisa en el log de las claves caducadas o que caducan dentro
+// del plazo configurado en 'daemon.key_expiry_warning_days'.
+func warnExpiringKeys(cfg *config.Config) {
+	if cfg.Daemon.KeyExpiryWarningDays < 0 {
+		return
+	}
+	now := time.Now()
+	for _, key := range cfg.ExpiringKeys(now, time.Duration(cfg.Daemon.KeyExpiryWarningDays)*24*time.Hour) {
+		attrs := []any{"user", key.User, "not_after", key.NotAfter}
+		if key.ActionID != "" {
+			attrs = append(attrs, "action_id", key.ActionID)
+		}
+		if !key.NotAfter.After(now) {
+			slog.Warn("Clave caducada: los knocks serán rechazados", attrs...)
+			continue
+		}
+		attrs = append(attrs, "days_left", int(key.NotAfter.Sub(now).Hours()/24))
+		slog.Warn("Clave próxima a caducar", attrs...)
 	}
 }
 
+// unknownActionLabel sustituye en las métricas a los IDs de acción que no
+// figuran en la configuración, para que un cliente autenticado no pueda crear
+// series nuevas a voluntad.
+const unknownActionLabel = "unknown"
+
+// countDrop contabiliza un knock descartado en las métricas.
+func (s *Server) countDrop(reason, user, actionID string) {
+	if _, exists := s.currentConfig().Actions[actionID]; actionID != "" && !exists {
+		actionID = unknownActionLabel
+	}
+	metrics.KnocksDropped.WithLabelValues(reason, user, actionID).Inc()
+}
+
+// cooldownKey identifica el cooldown de una acción para un usuario, sea cual
+// sea la clave con la que firmó. Es una estructura y no una cadena para que
+// ningún carácter del nombre (p. ej. ':' en el titular de un certificado)
+// pueda confundir al usuario con la acción.
+type cooldownKey struct {
+	user   string
+	action string
+}
+
+// effectiveCooldown devuelve el cooldown que se aplica a una acción.
+func effectiveCooldown(action config.Action) time.Duration {
+	if action.CooldownSeconds >= 0 {
+		return time.Duration(action.CooldownSeconds) * time.Second
+	}
+	return time.Duration(actionCooldownSeconds) * time.Second
+}
+
+// isSourceAllowed indica si el usuario puede llamar desde la IP dada. Sin
+// 'source_ips', cualquier origen es válido.
+func isSourceAllowed(user *config.User, sourceIP net.IP) bool {
+	if len(user.SourceCIDRs) == 0 {
+		return true
+	}
+	for _, cidr := range user.SourceCIDRs {
+		if cidr.Contains(sourceIP) {
+			return true
+		}
+	}
+	return false
+}
+
 func isActionAllowed(action string, allowedActions []string) bool {
 	for _, a := range allowedActions {
 		if a == action {
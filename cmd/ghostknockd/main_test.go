@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/executor"
+	"github.com/your-org/ghostknock/internal/listener"
+	"github.com/your-org/ghostknock/internal/metrics"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// testConfig devuelve una configuración mínima con el usuario "alice", dueño de
+// publicKey, y las acciones dadas en YAML (indentadas bajo 'actions:').
+func testConfig(publicKey ed25519.PublicKey, actions string) string {
+	return fmt.Sprintf(`listener:
+  interface: "any"
+  port: 3001
+logging:
+  log_level: "info"
+users:
+  - name: "alice"
+    public_key: "%s"
+    actions: ["open-ssh"]
+actions:
+%s`, base64.StdEncoding.EncodeToString(publicKey), actions)
+}
+
+const openSSHAction = `  "open-ssh":
+    command: "true"
+`
+
+// newTestServer escribe la configuración en un directorio temporal y prepara un
+// Server que la usa, como haría main sin arrancar el listener.
+func newTestServer(t *testing.T, configYAML string) *Server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(configYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return &Server{
+		configPath:      path,
+		config:          cfg,
+		logLevel:        new(slog.LevelVar),
+		actionCooldowns: make(map[cooldownKey]time.Time),
+		ipLimiters:      make(map[string]*ipLimiter),
+		seenNonces:      newNonceCache(replayWindowSeconds*time.Second, maxNoncesPerKey),
+		seenSignatures:  newSignatureCache(replayWindowSeconds*time.Second, maxRecentSignatures),
+		reverts:         executor.NewScheduler(""),
+		approvals:       newApprovalStore(),
+		reloadRequests:  make(chan chan error),
+		stopped:         make(chan struct{}),
+	}
+}
+
+// signKnock firma el payload como lo haría el cliente y lo devuelve como el
+// paquete recibido desde sourceIP.
+func signKnock(t *testing.T, privateKey ed25519.PrivateKey, payload *protocol.Payload, sourceIP net.IP) listener.PacketInfo {
+	t.Helper()
+	body, err := payload.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := protocol.NewEnvelope(body, 0, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener.PacketInfo{Payload: data, SourceIP: sourceIP, SourcePort: 40000}
+}
+
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey, privateKey
+}
+
+// waitForFile espera a que un comando cree el archivo marcador.
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("el comando no llegó a ejecutarse (%s no existe)", path)
+}
+
+func TestReloadConfig(t *testing.T) {
+	publicKey, _ := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, openSSHAction))
+	s.actionCooldowns[cooldownKey{"alice", "open-ssh"}] = time.Now()
+	scheduleRevert(s, config.Action{RevertDelaySeconds: 3600}, filepath.Join(t.TempDir(), "reverted"))
+
+	// Una configuración válida sustituye a la anterior sin tocar el estado en memoria.
+	updated := testConfig(publicKey, openSSHAction+`  "restart-web":
+    command: "true"
+`)
+	if err := os.WriteFile(s.configPath, []byte(updated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	newCfg, err := s.reloadConfig()
+	if err != nil {
+		t.Fatalf("reloadConfig() rechazó una configuración válida: %v", err)
+	}
+	if s.currentConfig() != newCfg {
+		t.Error("la configuración activa no es la recién cargada")
+	}
+	if _, exists := s.currentConfig().Actions["restart-web"]; !exists {
+		t.Error("la acción nueva no está en la configuración activa")
+	}
+	if _, exists := s.actionCooldowns[cooldownKey{"alice", "open-ssh"}]; !exists {
+		t.Error("la recarga borró los cooldowns")
+	}
+	if pending := s.reverts.Find("alice", "open-ssh", aliceIP.String()); len(pending) != 1 {
+		t.Errorf("reversiones pendientes tras la recarga = %d, se esperaba 1", len(pending))
+	}
+
+	// Una configuración inválida se descarta y se conserva la anterior.
+	if err := os.WriteFile(s.configPath, []byte("users: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.reloadConfig(); err == nil {
+		t.Fatal("reloadConfig() aceptó una configuración inválida")
+	}
+	if s.currentConfig() != newCfg {
+		t.Error("una recarga fallida cambió la configuración activa")
+	}
+}
+
+func TestReloadViaControlSocket(t *testing.T) {
+	publicKey, _ := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, openSSHAction))
+
+	// Mientras el bucle principal atiende las recargas, Reload devuelve su resultado.
+	go func() {
+		reply := <-s.reloadRequests
+		_, err := s.reloadConfig()
+		reply <- err
+	}()
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+
+	// Con el bucle ya detenido, Reload falla en lugar de bloquear la conexión de control.
+	close(s.stopped)
+	done := make(chan error, 1)
+	go func() { done <- s.Reload() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errDaemonStopping) {
+			t.Errorf("Reload() tras la parada = %v, se esperaba %v", err, errDaemonStopping)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload() se bloqueó con el demonio detenido")
+	}
+}
+
+func TestCountDropLabelsUnknownActions(t *testing.T) {
+	publicKey, _ := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, openSSHAction))
+
+	s.countDrop("test_drop", "alice", "open-ssh")
+	s.countDrop("test_drop", "alice", "no-existe")
+	s.countDrop("test_drop", "", "")
+
+	// DeleteLabelValues indica si la serie existía.
+	for _, action := range []string{"open-ssh", unknownActionLabel, ""} {
+		user := "alice"
+		if action == "" {
+			user = ""
+		}
+		if !metrics.KnocksDropped.DeleteLabelValues("test_drop", user, action) {
+			t.Errorf("no se contabilizó el descarte con action=%q", action)
+		}
+	}
+	if metrics.KnocksDropped.DeleteLabelValues("test_drop", "alice", "no-existe") {
+		t.Error("un ID de acción desconocido creó su propia serie")
+	}
+}
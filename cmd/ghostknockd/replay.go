@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// nonceCache recuerda los nonces ya aceptados de cada clave pública durante la
+// ventana anti-replay. Un knock solo puede aceptarse una vez: cualquier copia
+// capturada en la red que llegue dentro de la ventana se rechaza por su nonce,
+// y fuera de ella por su timestamp.
+type nonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	maxPerKey int
+	seen      map[string]map[string]time.Time // clave pública -> nonce -> instante de aceptación
+}
+
+func newNonceCache(ttl time.Duration, maxPerKey int) *nonceCache {
+	return &nonceCache{
+		ttl:       ttl,
+		maxPerKey: maxPerKey,
+		seen:      make(map[string]map[string]time.Time),
+	}
+}
+
+// nonceCheckResult indica el resultado de consultar un nonce en la caché.
+type nonceCheckResult int
+
+const (
+	nonceAccepted nonceCheckResult = iota
+	nonceReplayed
+	nonceCacheFull // La clave ha agotado su cupo de nonces dentro de la ventana.
+)
+
+// checkAndStore registra el nonce para la clave dada si no se había visto antes.
+// La comprobación y el registro son atómicos, de modo que dos copias del mismo
+// paquete procesadas a la vez no pueden ser aceptadas ambas.
+func (c *nonceCache) checkAndStore(publicKey, nonce string) nonceCheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	nonces, ok := c.seen[publicKey]
+	if !ok {
+		nonces = make(map[string]time.Time)
+		c.seen[publicKey] = nonces
+	}
+
+	if seenAt, exists := nonces[nonce]; exists && now.Sub(seenAt) <= c.ttl {
+		return nonceReplayed
+	}
+
+	if len(nonces) >= c.maxPerKey {
+		c.purgeKeyLocked(nonces, now)
+		if len(nonces) >= c.maxPerKey {
+			return nonceCacheFull
+		}
+	}
+
+	nonces[nonce] = now
+	return nonceAccepted
+}
+
+// purge elimina los nonces cuya ventana ya ha expirado y devuelve cuántos se borraron.
+func (c *nonceCache) purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	purgedCount := 0
+	for key, nonces := range c.seen {
+		purgedCount += c.purgeKeyLocked(nonces, now)
+		if len(nonces) == 0 {
+			delete(c.seen, key)
+		}
+	}
+	return purgedCount
+}
+
+func (c *nonceCache) purgeKeyLocked(nonces map[string]time.Time, now time.Time) int {
+	purgedCount := 0
+	for nonce, seenAt := range nonces {
+		if now.Sub(seenAt) > c.ttl {
+			delete(nonces, nonce)
+			purgedCount++
+		}
+	}
+	return purgedCount
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// age retrasa todos los nonces registrados de la clave, como si hubiera pasado d.
+func (c *nonceCache) age(key string, d time.Duration) {
+	for nonce, seenAt := range c.seen[key] {
+		c.seen[key][nonce] = seenAt.Add(-d)
+	}
+}
+
+func TestNonceCacheRejectsReplayWithinWindow(t *testing.T) {
+	cache := newNonceCache(time.Minute, 10)
+
+	if got := cache.checkAndStore("alice", "n1"); got != nonceAccepted {
+		t.Fatalf("primer uso del nonce = %d, se esperaba nonceAccepted", got)
+	}
+	cache.age("alice", 30*time.Second)
+	if got := cache.checkAndStore("alice", "n1"); got != nonceReplayed {
+		t.Fatalf("repetición dentro de la ventana = %d, se esperaba nonceReplayed", got)
+	}
+	// Los nonces son por clave: el mismo valor de otra clave es un knock distinto.
+	if got := cache.checkAndStore("bob", "n1"); got != nonceAccepted {
+		t.Fatalf("mismo nonce con otra clave = %d, se esperaba nonceAccepted", got)
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	const ttl = time.Minute
+	cache := newNonceCache(ttl, 2)
+
+	cache.checkAndStore("alice", "n1")
+	cache.checkAndStore("alice", "n2")
+	if got := cache.checkAndStore("alice", "n3"); got != nonceCacheFull {
+		t.Fatalf("con el cupo agotado = %d, se esperaba nonceCacheFull", got)
+	}
+
+	// Pasada la ventana, el nonce puede volver a aceptarse (lo rechazará su
+	// timestamp) y los nonces expirados liberan el cupo de la clave.
+	cache.age("alice", ttl+time.Second)
+	if got := cache.checkAndStore("alice", "n1"); got != nonceAccepted {
+		t.Fatalf("nonce expirado = %d, se esperaba nonceAccepted", got)
+	}
+	if got := cache.checkAndStore("alice", "n3"); got != nonceAccepted {
+		t.Fatalf("tras expirar el cupo = %d, se esperaba nonceAccepted", got)
+	}
+}
+
+func TestNonceCachePurge(t *testing.T) {
+	const ttl = time.Minute
+	cache := newNonceCache(ttl, 10)
+	cache.checkAndStore("alice", "old")
+	cache.checkAndStore("bob", "old")
+	cache.age("alice", ttl+time.Second)
+	cache.age("bob", ttl+time.Second)
+	cache.checkAndStore("alice", "fresh")
+
+	if purged := cache.purge(); purged != 2 {
+		t.Fatalf("purge() = %d, se esperaba 2", purged)
+	}
+	if _, ok := cache.seen["bob"]; ok {
+		t.Error("purge() no eliminó la clave que se quedó sin nonces")
+	}
+	if got := cache.checkAndStore("alice", "fresh"); got != nonceReplayed {
+		t.Errorf("el nonce vigente no sobrevivió a purge(): checkAndStore() = %d", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// handleControlVerb aplica un verbo de control sobre las reversiones pendientes
+// que el mismo usuario programó para la misma acción desde la misma IP.
+// Devuelve false si el verbo no se ha podido aplicar.
+func (s *Server) handleControlVerb(user *config.User, action config.Action, payload *protocol.Payload, sourceIP net.IP) bool {
+	pending := s.reverts.Find(user.Name, payload.ActionID, sourceIP.String())
+	if len(pending) == 0 {
+		slog.Warn("Verbo de control descartado",
+			"reason", "no_pending_revert",
+			"verb", payload.Verb,
+			"user", user.Name,
+			"action_id", payload.ActionID,
+			"source_ip", sourceIP.String(),
+		)
+		s.countDrop("no_pending_revert", user.Name, payload.ActionID)
+		return false
+	}
+
+	switch payload.Verb {
+	case protocol.VerbRevertNow:
+		for _, rev := range pending {
+			slog.Info("Reversión anticipada solicitada por el cliente",
+				"revert_id", rev.ID,
+				"user", user.Name,
+				"action_id", payload.ActionID,
+				"source_ip", sourceIP.String(),
+			)
+			if err := s.reverts.RunNow(rev.ID); err != nil {
+				slog.Info("La reversión no se relanzó", "revert_id", rev.ID, "error", err)
+			}
+		}
+
+	case protocol.VerbExtend:
+		if action.MaxLifetimeSeconds == 0 {
+			slog.Warn("Verbo de control descartado",
+				"reason", "extension_not_allowed",
+				"user", user.Name,
+				"action_id", payload.ActionID,
+				"source_ip", sourceIP.String(),
+			)
+			s.countDrop("extension_not_allowed", user.Name, payload.ActionID)
+			return false
+		}
+
+		maxLifetime := time.Duration(action.MaxLifetimeSeconds) * time.Second
+		requestedDue := time.Now().Add(time.Duration(payload.ExtendSeconds) * time.Second)
+		for _, rev := range pending {
+			// La vida total de la acción nunca supera max_lifetime_seconds desde su ejecución.
+			dueAt := requestedDue
+			if limit := rev.CreatedAt.Add(maxLifetime); dueAt.After(limit) {
+				dueAt = limit
+			}
+			if !s.reverts.Reschedule(rev.ID, dueAt) {
+				continue
+			}
+			slog.Info("Reversión reprogramada por el cliente",
+				"revert_id", rev.ID,
+				"user", user.Name,
+				"action_id", payload.ActionID,
+				"source_ip", sourceIP.String(),
+				"requested_seconds", payload.ExtendSeconds,
+				"capped", dueAt.Before(requestedDue),
+				"due_at", dueAt.Format(time.RFC3339),
+			)
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/executor"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+var (
+	aliceIP = net.ParseIP("192.0.2.10")
+	otherIP = net.ParseIP("192.0.2.20")
+)
+
+// scheduleRevert programa, como si alice acabara de ejecutar open-ssh desde
+// aliceIP, una reversión que crea el archivo marker.
+func scheduleRevert(s *Server, action config.Action, marker string) {
+	action.RevertCommand = "touch " + marker
+	s.reverts.Schedule(executor.Request{
+		ActionID: "open-ssh",
+		User:     "alice",
+		Action:   action,
+		SourceIP: aliceIP,
+	})
+}
+
+func TestControlVerbRevertNow(t *testing.T) {
+	publicKey, _ := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, openSSHAction))
+	alice := &s.currentConfig().Users[0]
+	action := config.Action{RevertDelaySeconds: 3600}
+	marker := filepath.Join(t.TempDir(), "reverted")
+	scheduleRevert(s, action, marker)
+
+	// Otro origen no puede cerrar el acceso que abrió alice desde aliceIP.
+	s.handleControlVerb(alice, action, &protocol.Payload{ActionID: "open-ssh", Verb: protocol.VerbRevertNow}, otherIP)
+	if len(s.reverts.Find("alice", "open-ssh", aliceIP.String())) != 1 {
+		t.Fatal("un verbo desde otra IP alteró la reversión pendiente")
+	}
+
+	s.handleControlVerb(alice, action, &protocol.Payload{ActionID: "open-ssh", Verb: protocol.VerbRevertNow}, aliceIP)
+	waitForFile(t, marker)
+	// La reversión sale de las pendientes cuando su comando termina.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.reverts.Find("alice", "open-ssh", aliceIP.String())) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("la reversión sigue pendiente tras revert-now")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlVerbExtend(t *testing.T) {
+	publicKey, _ := generateKey(t)
+	s := newTestServer(t, testConfig(publicKey, openSSHAction))
+	alice := &s.currentConfig().Users[0]
+	marker := filepath.Join(t.TempDir(), "reverted")
+
+	extend := func(action config.Action, seconds int) time.Time {
+		t.Helper()
+		s.handleControlVerb(alice, action, &protocol.Payload{ActionID: "open-ssh", Verb: protocol.VerbExtend, ExtendSeconds: seconds}, aliceIP)
+		pending := s.reverts.Find("alice", "open-ssh", aliceIP.String())
+		if len(pending) != 1 {
+			t.Fatalf("reversiones pendientes = %d, se esperaba 1", len(pending))
+		}
+		return pending[0].DueAt
+	}
+
+	// Sin max_lifetime_seconds, la acción no admite prórrogas.
+	fixed := config.Action{RevertDelaySeconds: 60}
+	scheduleRevert(s, fixed, marker)
+	originalDue := s.reverts.Find("alice", "open-ssh", aliceIP.String())[0].DueAt
+	if dueAt := extend(fixed, 600); !dueAt.Equal(originalDue) {
+		t.Errorf("se prorrogó una acción sin max_lifetime_seconds: %v -> %v", originalDue, dueAt)
+	}
+
+	// Con max_lifetime_seconds, la prórroga nunca supera la vida máxima desde la ejecución.
+	extendable := config.Action{RevertDelaySeconds: 60, MaxLifetimeSeconds: 300}
+	createdAt := s.reverts.Find("alice", "open-ssh", aliceIP.String())[0].CreatedAt
+	if dueAt := extend(extendable, 120); dueAt.Sub(time.Now()) < 110*time.Second || dueAt.After(createdAt.Add(300*time.Second)) {
+		t.Errorf("prórroga de 120s: vencimiento en %v", time.Until(dueAt))
+	}
+	if dueAt := extend(extendable, 3600); !dueAt.Equal(createdAt.Add(300 * time.Second)) {
+		t.Errorf("prórroga de 3600s: vencimiento %v, se esperaba el límite %v", dueAt, createdAt.Add(300*time.Second))
+	}
+}
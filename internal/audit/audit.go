@@ -0,0 +1,164 @@
+// El paquete audit escribe el registro de auditoría de ghostknockd: un archivo
+// JSON Lines, solo de anexado, con un evento por línea y un esquema estable
+// pensado para ser ingerido por un SIEM. Es independiente del log operativo.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// SchemaVersion es la versión del esquema de los eventos. Solo se incrementa si
+// un campo existente cambia de significado o de tipo; añadir campos no la altera.
+const SchemaVersion = 1
+
+const auditFilePerms = 0600
+
+// Tipos de evento.
+const (
+	EventKnockAccepted     = "knock_accepted"
+	EventCommandExecuted   = "command_executed"
+	EventCommandRejected   = "command_rejected"
+	EventRevertScheduled   = "revert_scheduled"
+	EventRevertRescheduled = "revert_rescheduled"
+	EventRevertCancelled   = "revert_cancelled"
+	EventApprovalRequested = "approval_requested"
+	EventApprovalGranted   = "approval_granted"
+	EventApprovalExpired   = "approval_expired"
+)
+
+// Event es una línea del registro de auditoría. Los campos vacíos se omiten.
+type Event struct {
+	Time          time.Time         `json:"time"`
+	SchemaVersion int               `json:"schema_version"`
+	Event         string            `json:"event"`
+	User          string            `json:"user,omitempty"`
+	ActionID      string            `json:"action_id,omitempty"`
+	SourceIP      string            `json:"source_ip,omitempty"`
+	KeyID         string            `json:"key_id,omitempty"`
+	Nonce         string            `json:"nonce,omitempty"`
+	Verb          string            `json:"verb,omitempty"`
+	Params        map[string]string `json:"params,omitempty"`
+	CommandType   string            `json:"command_type,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	RunAsUser     string            `json:"run_as_user,omitempty"`
+	Status        string            `json:"status,omitempty"`
+	ExitCode      *int              `json:"exit_code,omitempty"`
+	DurationMS    *int64            `json:"duration_ms,omitempty"`
+	RevertID      string            `json:"revert_id,omitempty"`
+	DueAt         *time.Time        `json:"due_at,omitempty"`
+	RequestID     string            `json:"request_id,omitempty"`
+	Approvers     []string          `json:"approvers,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// Logger escribe eventos de auditoría en un archivo.
+type Logger struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger *Logger
+)
+
+// Open abre (o crea) el archivo de auditoría en modo anexado.
+func Open(path string) (*Logger, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, auditFilePerms)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo abrir el registro de auditoría en '%s': %w", path, err)
+	}
+	return &Logger{path: path, file: file}, nil
+}
+
+// Reopen cierra y vuelve a abrir el archivo, para cooperar con logrotate.
+func (l *Logger) Reopen() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, auditFilePerms)
+	if err != nil {
+		return fmt.Errorf("no se pudo reabrir el registro de auditoría en '%s': %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	old := l.file
+	l.file = file
+	l.mu.Unlock()
+	return old.Close()
+}
+
+// Path devuelve la ruta del archivo de auditoría.
+func (l *Logger) Path() string {
+	return l.path
+}
+
+// Close cierra el archivo de auditoría.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Write añade un evento al archivo. Cada evento se escribe con una única
+// llamada a write(2), de modo que las líneas nunca se entremezclan.
+func (l *Logger) Write(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	ev.SchemaVersion = SchemaVersion
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("No se pudo serializar el evento de auditoría", "event", ev.Event, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil {
+		slog.Error("No se pudo escribir en el registro de auditoría", "path", l.path, "event", ev.Event, "error", err)
+	}
+}
+
+// SetDefault establece el registro de auditoría usado por Record. nil lo desactiva.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// Default devuelve el registro de auditoría activo, o nil si está desactivado.
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// Record escribe un evento en el registro por defecto, si hay uno configurado.
+func Record(ev Event) {
+	if l := Default(); l != nil {
+		l.Write(ev)
+	}
+}
+
+// Int devuelve un puntero a n, para los campos opcionales de Event.
+func Int(n int) *int {
+	return &n
+}
+
+// Duration devuelve la duración en milisegundos, para el campo DurationMS.
+func Duration(d time.Duration) *int64 {
+	ms := d.Milliseconds()
+	return &ms
+}
+
+// Time devuelve un puntero a t en UTC, para los campos opcionales de Event.
+func Time(t time.Time) *time.Time {
+	t = t.UTC()
+	return &t
+}
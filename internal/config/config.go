@@ -2,26 +2,112 @@
 package config
 
 import (
+	"crypto/ecdh"
 	"crypto/ed25519"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/user"
+	"sort"
+	"time"
 
+	"github.com/your-org/ghostknock/internal/keyfile"
+	"github.com/your-org/ghostknock/internal/protocol"
 	"gopkg.in/yaml.v3"
 )
 
 // Daemon define la configuración del comportamiento del proceso del servidor.
 type Daemon struct {
 	PIDFile string `yaml:"pid_file,omitempty"`
+	// RevertJournal es el archivo donde se persisten las reversiones pendientes
+	// para reanudarlas si el demonio se reinicia.
+	RevertJournal string `yaml:"revert_journal,omitempty"`
+	// ControlSocket es el socket Unix de administración local usado por ghostknockctl.
+	ControlSocket string `yaml:"control_socket,omitempty"`
+	// RevocationFile es el archivo de claves revocadas, independiente de este
+	// archivo de configuración. El demonio lo vigila y lo recarga al cambiar.
+	RevocationFile string `yaml:"revocation_file,omitempty"`
+	// KeyExpiryWarningDays es la antelación con la que se avisa en el log de las
+	// claves que van a caducar. Un valor negativo desactiva el aviso.
+	KeyExpiryWarningDays int `yaml:"key_expiry_warning_days,omitempty"`
 }
 
+const (
+	// DefaultRevertJournal es la ruta del diario de reversiones si no se configura otra.
+	DefaultRevertJournal = "/var/lib/ghostknock/reverts.json"
+	// DefaultControlSocket es la ruta del socket de control si no se configura otra.
+	DefaultControlSocket = "/run/ghostknock/ghostknockd.sock"
+	// DefaultKeyExpiryWarningDays es la antelación por defecto del aviso de caducidad.
+	DefaultKeyExpiryWarningDays = 14
+)
+
+// Server define la identidad criptográfica propia del servidor.
+type Server struct {
+	// SealingKeyFile es la ruta a la clave privada X25519 (32 bytes en bruto) con
+	// la que el servidor descifra los payloads sellados por los clientes.
+	SealingKeyFile string `yaml:"sealing_key_file,omitempty"`
+	// RequireSealed rechaza cualquier knock cuyo payload viaje en claro.
+	RequireSealed bool             `yaml:"require_sealed,omitempty"`
+	SealingKey    *ecdh.PrivateKey `yaml:"-"`
+	// SigningKeyFile es la ruta a la clave privada ed25519 del servidor con la que
+	// firma los acuses de recibo: en bruto (ghostknock-keygen) o de OpenSSH, sin
+	// cifrar, porque el demonio no puede pedir una frase de paso al arrancar.
+	SigningKeyFile string             `yaml:"signing_key_file,omitempty"`
+	SigningKey     ed25519.PrivateKey `yaml:"-"`
+	// TrustedCAKeys son las claves públicas ed25519 (Base64 o ssh-ed25519) de las
+	// CA cuyos certificados se aceptan en lugar de una entrada en 'users'.
+	TrustedCAKeys []string                                       `yaml:"trusted_ca_keys,omitempty"`
+	CAKeys        map[[protocol.KeyIDSize]byte]ed25519.PublicKey `yaml:"-"`
+}
+
+// Metrics define el endpoint HTTP opcional de métricas Prometheus.
+type Metrics struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+}
+
+const (
+	// DefaultMetricsListen limita por defecto las métricas a localhost.
+	DefaultMetricsListen = "127.0.0.1:9477"
+	// DefaultMetricsPath es la ruta HTTP por defecto de las métricas.
+	DefaultMetricsPath = "/metrics"
+)
+
 // Logging define la configuración para los registros del servidor.
 type Logging struct {
 	LogLevel string `yaml:"log_level"`
+	// Output es el destino del log operativo: "file", "stderr" o "syslog".
+	Output string `yaml:"output,omitempty"`
+	// File es la ruta del log cuando Output es "file".
+	File string `yaml:"file,omitempty"`
+	// Format es el formato de cada línea: "text" o "json".
+	Format string `yaml:"format,omitempty"`
+	Syslog Syslog `yaml:"syslog,omitempty"`
+	// AuditFile es la ruta del registro de auditoría en JSON Lines. Vacío lo desactiva.
+	AuditFile string `yaml:"audit_file,omitempty"`
 }
 
+// Syslog define el colector RFC 5424 usado cuando logging.output es "syslog".
+type Syslog struct {
+	// Network es "unixgram" (socket local) o "udp".
+	Network  string `yaml:"network,omitempty"`
+	Address  string `yaml:"address,omitempty"`
+	Facility string `yaml:"facility,omitempty"`
+	// Tag es el APP-NAME de los mensajes.
+	Tag string `yaml:"tag,omitempty"`
+}
+
+const (
+	// DefaultLogFile es la ruta del log cuando la salida es "file" y no se indica otra.
+	DefaultLogFile = "/var/log/ghostknockd.log"
+	// DefaultSyslogAddress es el socket local de syslog.
+	DefaultSyslogAddress = "/dev/log"
+	// DefaultSyslogUDPAddress es el colector por defecto cuando la red es "udp".
+	DefaultSyslogUDPAddress = "127.0.0.1:514"
+)
+
 // Action define una plantilla de comando y su comportamiento de reversión.
 type Action struct {
 	Command            string `yaml:"command"`
@@ -30,15 +116,59 @@ type Action struct {
 	TimeoutSeconds     int    `yaml:"timeout_seconds,omitempty"`
 	CooldownSeconds    int    `yaml:"cooldown_seconds,omitempty"`
 	RunAsUser          string `yaml:"run_as_user,omitempty"`
+	// MaxLifetimeSeconds limita cuánto puede prolongarse una acción con el verbo
+	// de control 'extend', contado desde su ejecución. 0 deshabilita las prórrogas.
+	MaxLifetimeSeconds int `yaml:"max_lifetime_seconds,omitempty"`
+	// Acknowledge hace que el servidor responda al cliente con un acuse de
+	// recibo firmado indicando el resultado del knock.
+	Acknowledge bool `yaml:"acknowledge,omitempty"`
+	// ReturnOutput devuelve al cliente, cifrada y firmada, la salida del comando.
+	// Implica el acuse de recibo.
+	ReturnOutput bool `yaml:"return_output,omitempty"`
+	// MaxOutputBytes limita la salida devuelta (stdout y stderr en total).
+	MaxOutputBytes int `yaml:"max_output_bytes,omitempty"`
+	// RequireApprovals es el número de aprobaciones de usuarios distintos del
+	// solicitante, todos de Approvers, necesarias para ejecutar la acción.
+	RequireApprovals int      `yaml:"require_approvals,omitempty"`
+	Approvers        []string `yaml:"approvers,omitempty"`
+	// ApprovalWindowSeconds es el plazo para reunir las aprobaciones desde la solicitud.
+	ApprovalWindowSeconds int `yaml:"approval_window_seconds,omitempty"`
+	// AllowedSchedule limita los días y horas en los que puede solicitarse la acción.
+	AllowedSchedule *Schedule `yaml:"allowed_schedule,omitempty"`
 }
 
+const (
+	// DefaultMaxOutputBytes es la salida devuelta si la acción no indica otro límite.
+	DefaultMaxOutputBytes = 4096
+	// MaxOutputBytesLimit es el máximo admitido para 'max_output_bytes'.
+	MaxOutputBytesLimit = 32768
+	// DefaultApprovalWindowSeconds es el plazo para reunir las aprobaciones si la acción no indica otro.
+	DefaultApprovalWindowSeconds = 300
+)
+
+// errEncryptedSigningKey señala que 'signing_key_file' está cifrada: el demonio
+// arranca sin terminal y no puede pedir la frase de paso.
+var errEncryptedSigningKey = errors.New("la clave de firma está cifrada")
+
 // Config es la estructura raíz de nuestro archivo de configuración.
 type Config struct {
 	Listener Listener          `yaml:"listener"`
+	Server   Server            `yaml:"server"`
 	Logging  Logging           `yaml:"logging"`
+	Metrics  Metrics           `yaml:"metrics"`
 	Daemon   Daemon            `yaml:"daemon"`
 	Users    []User            `yaml:"users"`
 	Actions  map[string]Action `yaml:"actions"`
+
+	// keysByID indexa las claves públicas de los usuarios por su identificador
+	// corto, de modo que el demonio verifica una sola firma por paquete.
+	keysByID map[[protocol.KeyIDSize]byte]userKey
+}
+
+// userKey asocia una clave pública autorizada con su usuario.
+type userKey struct {
+	user      *User
+	publicKey ed25519.PublicKey
 }
 
 // Listener define en qué interfaz y puerto escucha el servidor.
@@ -48,14 +178,84 @@ type Listener struct {
 	ListenIP  string `yaml:"listen_ip,omitempty"`
 }
 
-// User define un usuario autorizado.
+// User define un usuario autorizado. La clave pública puede indicarse en Base64
+// (formato de ghostknock-keygen) o como una línea 'ssh-ed25519 AAAA... comentario',
+// y AuthorizedKeysFile permite añadir más claves desde un archivo al estilo de
+// authorized_keys de OpenSSH.
 type User struct {
-	Name             string   `yaml:"name"`
-	PublicKeyB64     string   `yaml:"public_key"`
-	AllowedActions   []string `yaml:"actions"`
-	SourceIPs        []string `yaml:"source_ips,omitempty"` // <<-- NUEVO CAMPO
-	DecodedPublicKey ed25519.PublicKey
-	SourceCIDRs      []*net.IPNet // Campo interno para redes pre-parseadas
+	Name               string   `yaml:"name"`
+	PublicKeyB64       string   `yaml:"public_key,omitempty"`
+	AuthorizedKeysFile string   `yaml:"authorized_keys_file,omitempty"`
+	AllowedActions     []string `yaml:"actions"`
+	SourceIPs          []string `yaml:"source_ips,omitempty"` // <<-- NUEVO CAMPO
+	// Validity limita el periodo en el que las claves del usuario son válidas.
+	Validity `yaml:",inline"`
+	// ActionValidity restringe además el periodo de validez de acciones concretas.
+	ActionValidity map[string]Validity `yaml:"action_validity,omitempty"`
+	// AllowedSchedule limita los días y horas en los que el usuario puede llamar.
+	AllowedSchedule *Schedule           `yaml:"allowed_schedule,omitempty"`
+	PublicKeys      []ed25519.PublicKey `yaml:"-"` // Todas las claves autorizadas del usuario
+	SourceCIDRs     []*net.IPNet        // Campo interno para redes pre-parseadas
+	// Certificate es el certificado del que se derivó el usuario, o nil si figura en 'users'.
+	Certificate *protocol.Certificate `yaml:"-"`
+}
+
+// Validity es un periodo de validez opcional. Un extremo a cero no limita.
+type Validity struct {
+	NotBefore time.Time `yaml:"not_before,omitempty"`
+	NotAfter  time.Time `yaml:"not_after,omitempty"`
+}
+
+// Contains indica si el instante dado está dentro del periodo de validez.
+func (v Validity) Contains(t time.Time) bool {
+	if !v.NotBefore.IsZero() && t.Before(v.NotBefore) {
+		return false
+	}
+	return v.NotAfter.IsZero() || t.Before(v.NotAfter)
+}
+
+// validate comprueba que el periodo no está vacío.
+func (v Validity) validate() error {
+	if !v.NotBefore.IsZero() && !v.NotAfter.IsZero() && !v.NotAfter.After(v.NotBefore) {
+		return fmt.Errorf("'not_after' (%s) debe ser posterior a 'not_before' (%s)", v.NotAfter.Format(time.RFC3339), v.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// KeyExpiry describe una clave cuya validez termina pronto (o ya terminó).
+type KeyExpiry struct {
+	User     string
+	ActionID string // Vacío si la caducidad es la del usuario completo.
+	NotAfter time.Time
+}
+
+// ExpiringKeys devuelve los usuarios y acciones cuya validez termina antes de
+// now+within, incluidos los ya caducados, ordenados por fecha de caducidad.
+func (c *Config) ExpiringKeys(now time.Time, within time.Duration) []KeyExpiry {
+	var expiring []KeyExpiry
+	limit := now.Add(within)
+	for _, user := range c.Users {
+		if !user.NotAfter.IsZero() && user.NotAfter.Before(limit) {
+			expiring = append(expiring, KeyExpiry{User: user.Name, NotAfter: user.NotAfter})
+		}
+		for actionID, validity := range user.ActionValidity {
+			if !validity.NotAfter.IsZero() && validity.NotAfter.Before(limit) {
+				expiring = append(expiring, KeyExpiry{User: user.Name, ActionID: actionID, NotAfter: validity.NotAfter})
+			}
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].NotAfter.Before(expiring[j].NotAfter) })
+	return expiring
+}
+
+// UserByKeyID devuelve el usuario y la clave pública con el identificador dado,
+// o nil si ninguno coincide. La búsqueda no verifica ninguna firma.
+func (c *Config) UserByKeyID(keyID [protocol.KeyIDSize]byte) (*User, ed25519.PublicKey) {
+	entry, ok := c.keysByID[keyID]
+	if !ok {
+		return nil, nil
+	}
+	return entry.user, entry.publicKey
 }
 
 // LoadConfig lee y parsea el archivo de configuración YAML desde la ruta especificada.
@@ -78,6 +278,71 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// validateApprovals comprueba las opciones de aprobación múltiple de una acción
+// y aplica el plazo por defecto. Los aprobadores deben figurar en userNames.
+func validateApprovals(actionName string, action *Action, userNames map[string]struct{}) error {
+	if action.RequireApprovals < 0 {
+		return fmt.Errorf("la acción '%s' tiene un 'require_approvals' negativo, lo cual no está permitido", actionName)
+	}
+	if action.ApprovalWindowSeconds < 0 {
+		return fmt.Errorf("la acción '%s' tiene un 'approval_window_seconds' negativo, lo cual no está permitido", actionName)
+	}
+	if action.RequireApprovals == 0 {
+		if len(action.Approvers) > 0 {
+			return fmt.Errorf("la acción '%s' define 'approvers' pero no 'require_approvals'", actionName)
+		}
+		return nil
+	}
+	// Solo el acuse 'pending' confirma al solicitante que la solicitud quedó
+	// registrada (y no descartada) y le devuelve el ID que deben aprobar los demás.
+	if !action.Acknowledge {
+		return fmt.Errorf("la acción '%s' tiene 'require_approvals' pero no 'acknowledge': el solicitante necesita el acuse con el ID de la solicitud", actionName)
+	}
+
+	approvers := make(map[string]struct{}, len(action.Approvers))
+	for _, name := range action.Approvers {
+		if _, exists := approvers[name]; exists {
+			return fmt.Errorf("la acción '%s' tiene el aprobador duplicado: '%s'", actionName, name)
+		}
+		if _, exists := userNames[name]; !exists {
+			return fmt.Errorf("la acción '%s' tiene un aprobador ('%s') que no está definido en 'users'", actionName, name)
+		}
+		approvers[name] = struct{}{}
+	}
+	if len(approvers) < action.RequireApprovals {
+		return fmt.Errorf("la acción '%s' requiere %d aprobaciones pero solo tiene %d aprobadores", actionName, action.RequireApprovals, len(approvers))
+	}
+	if action.ApprovalWindowSeconds == 0 {
+		action.ApprovalWindowSeconds = DefaultApprovalWindowSeconds
+	}
+	return nil
+}
+
+// IsApprover indica si el usuario puede aprobar las solicitudes de la acción.
+func (a Action) IsApprover(userName string) bool {
+	for _, name := range a.Approvers {
+		if name == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSigningKey lee 'signing_key_file' con el mismo cargador que el cliente
+// (en bruto u OpenSSH) y rechaza las claves cifradas.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	signingKey, err := keyfile.Load(path, func() ([]byte, error) {
+		return nil, errEncryptedSigningKey
+	})
+	if errors.Is(err, errEncryptedSigningKey) {
+		return nil, fmt.Errorf("la clave de firma '%s' está cifrada con frase de paso y el demonio no puede pedirla: use una clave sin cifrar (ghostknock-keygen sin -encrypt, o ssh-keygen -N '')", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer la clave de firma 'signing_key_file' en '%s': %w", path, err)
+	}
+	return signingKey, nil
+}
+
 // validateConfig realiza comprobaciones de sanidad en la configuración cargada.
 func validateConfig(cfg *Config) error {
 	if cfg.Listener.Port <= 0 || cfg.Listener.Port > 65535 {
@@ -92,6 +357,61 @@ func validateConfig(cfg *Config) error {
 		}
 	}
 
+	if cfg.Server.SealingKeyFile != "" {
+		keyBytes, err := os.ReadFile(cfg.Server.SealingKeyFile)
+		if err != nil {
+			return fmt.Errorf("no se pudo leer la clave de sellado 'sealing_key_file' en '%s': %w", cfg.Server.SealingKeyFile, err)
+		}
+		sealingKey, err := ecdh.X25519().NewPrivateKey(keyBytes)
+		if err != nil {
+			return fmt.Errorf("la clave de sellado '%s' no es una clave privada X25519 válida: %w", cfg.Server.SealingKeyFile, err)
+		}
+		cfg.Server.SealingKey = sealingKey
+	}
+	if cfg.Server.SigningKeyFile != "" {
+		signingKey, err := loadSigningKey(cfg.Server.SigningKeyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Server.SigningKey = signingKey
+	}
+	cfg.Server.CAKeys = make(map[[protocol.KeyIDSize]byte]ed25519.PublicKey, len(cfg.Server.TrustedCAKeys))
+	for i, value := range cfg.Server.TrustedCAKeys {
+		caKey, err := ParsePublicKey(value)
+		if err != nil {
+			return fmt.Errorf("la clave de CA en la posición %d de 'trusted_ca_keys' no es válida: %w", i, err)
+		}
+		cfg.Server.CAKeys[protocol.KeyIDFromPublicKey(caKey)] = caKey
+	}
+	if cfg.Server.RequireSealed && cfg.Server.SealingKey == nil {
+		return fmt.Errorf("'require_sealed' está activado pero no se ha configurado 'sealing_key_file'")
+	}
+
+	if cfg.Daemon.RevertJournal == "" {
+		cfg.Daemon.RevertJournal = DefaultRevertJournal
+	}
+	if cfg.Daemon.ControlSocket == "" {
+		cfg.Daemon.ControlSocket = DefaultControlSocket
+	}
+	if cfg.Daemon.KeyExpiryWarningDays == 0 {
+		cfg.Daemon.KeyExpiryWarningDays = DefaultKeyExpiryWarningDays
+	}
+
+	if cfg.Metrics.Listen == "" {
+		cfg.Metrics.Listen = DefaultMetricsListen
+	}
+	if cfg.Metrics.Path == "" {
+		cfg.Metrics.Path = DefaultMetricsPath
+	}
+	if cfg.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(cfg.Metrics.Listen); err != nil {
+			return fmt.Errorf("el campo 'metrics.listen' ('%s') no es una dirección host:puerto válida: %w", cfg.Metrics.Listen, err)
+		}
+		if cfg.Metrics.Path[0] != '/' {
+			return fmt.Errorf("el campo 'metrics.path' ('%s') debe comenzar por '/'", cfg.Metrics.Path)
+		}
+	}
+
 	// Validación para la configuración de logging.
 	if cfg.Logging.LogLevel == "" {
 		// Asignar un valor por defecto si no se especifica.
@@ -103,6 +423,9 @@ func validateConfig(cfg *Config) error {
 	default:
 		return fmt.Errorf("el valor de 'log_level' ('%s') es inválido; debe ser 'debug', 'info', 'warn' o 'error'", cfg.Logging.LogLevel)
 	}
+	if err := validateLogging(&cfg.Logging); err != nil {
+		return err
+	}
 
 	if len(cfg.Users) == 0 {
 		return fmt.Errorf("no se han definido usuarios en la sección 'users'")
@@ -111,24 +434,48 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("no se han definido acciones en la sección 'actions'")
 	}
 
+	cfg.keysByID = make(map[[protocol.KeyIDSize]byte]userKey, len(cfg.Users))
+	userNames := make(map[string]struct{}, len(cfg.Users))
 	for i := range cfg.Users {
 		user := &cfg.Users[i]
 
 		if user.Name == "" {
 			return fmt.Errorf("el usuario en la posición %d no tiene nombre ('name')", i)
 		}
-		if user.PublicKeyB64 == "" {
-			return fmt.Errorf("el usuario '%s' no tiene clave pública ('public_key')", user.Name)
+		if _, exists := userNames[user.Name]; exists {
+			return fmt.Errorf("el nombre de usuario '%s' está duplicado", user.Name)
+		}
+		userNames[user.Name] = struct{}{}
+		if user.PublicKeyB64 == "" && user.AuthorizedKeysFile == "" {
+			return fmt.Errorf("el usuario '%s' no tiene clave pública ('public_key' o 'authorized_keys_file')", user.Name)
 		}
 
-		pkBytes, err := base64.StdEncoding.DecodeString(user.PublicKeyB64)
-		if err != nil {
-			return fmt.Errorf("la clave pública del usuario '%s' no es un Base64 válido: %w", user.Name, err)
+		user.PublicKeys = nil
+		if user.PublicKeyB64 != "" {
+			publicKey, err := ParsePublicKey(user.PublicKeyB64)
+			if err != nil {
+				return fmt.Errorf("la clave pública del usuario '%s' no es válida: %w", user.Name, err)
+			}
+			user.PublicKeys = append(user.PublicKeys, publicKey)
+		}
+		if user.AuthorizedKeysFile != "" {
+			publicKeys, err := LoadAuthorizedKeys(user.AuthorizedKeysFile)
+			if err != nil {
+				return fmt.Errorf("el archivo 'authorized_keys_file' del usuario '%s' no es válido: %w", user.Name, err)
+			}
+			user.PublicKeys = append(user.PublicKeys, publicKeys...)
 		}
-		if len(pkBytes) != ed25519.PublicKeySize {
-			return fmt.Errorf("la clave pública del usuario '%s' tiene un tamaño incorrecto: se esperaban %d bytes, tiene %d", user.Name, ed25519.PublicKeySize, len(pkBytes))
+
+		for _, publicKey := range user.PublicKeys {
+			keyID := protocol.KeyIDFromPublicKey(publicKey)
+			if other, exists := cfg.keysByID[keyID]; exists {
+				if other.user == user {
+					return fmt.Errorf("el usuario '%s' tiene la misma clave pública repetida (o su identificador corto colisiona)", user.Name)
+				}
+				return fmt.Errorf("los usuarios '%s' y '%s' comparten la misma clave pública (o su identificador corto colisiona)", other.user.Name, user.Name)
+			}
+			cfg.keysByID[keyID] = userKey{user: user, publicKey: publicKey}
 		}
-		user.DecodedPublicKey = ed25519.PublicKey(pkBytes)
 
 		if len(user.AllowedActions) == 0 {
 			return fmt.Errorf("el usuario '%s' no tiene acciones permitidas ('actions')", user.Name)
@@ -141,7 +488,25 @@ func validateConfig(cfg *Config) error {
 			}
 			actionSet[action] = struct{}{}
 		}
-		
+
+		if err := user.Validity.validate(); err != nil {
+			return fmt.Errorf("el usuario '%s' tiene un periodo de validez inválido: %w", user.Name, err)
+		}
+		for actionID, validity := range user.ActionValidity {
+			if _, allowed := actionSet[actionID]; !allowed {
+				return fmt.Errorf("el usuario '%s' define 'action_validity' para '%s', que no está entre sus acciones", user.Name, actionID)
+			}
+			if err := validity.validate(); err != nil {
+				return fmt.Errorf("el usuario '%s' tiene un periodo de validez inválido para la acción '%s': %w", user.Name, actionID, err)
+			}
+		}
+
+		if user.AllowedSchedule != nil {
+			if err := user.AllowedSchedule.compile(); err != nil {
+				return fmt.Errorf("el usuario '%s' tiene un 'allowed_schedule' inválido: %w", user.Name, err)
+			}
+		}
+
 		// <<-- NUEVA VALIDACIÓN PARA SOURCE_IPS
 		if len(user.SourceIPs) > 0 {
 			user.SourceCIDRs = make([]*net.IPNet, 0, len(user.SourceIPs))
@@ -162,6 +527,38 @@ func validateConfig(cfg *Config) error {
 		if action.CooldownSeconds < 0 {
 			return fmt.Errorf("la acción '%s' tiene un 'cooldown_seconds' negativo, lo cual no está permitido", actionName)
 		}
+		if action.MaxLifetimeSeconds < 0 {
+			return fmt.Errorf("la acción '%s' tiene un 'max_lifetime_seconds' negativo, lo cual no está permitido", actionName)
+		}
+		if action.MaxLifetimeSeconds > 0 {
+			if action.RevertCommand == "" || action.RevertDelaySeconds <= 0 {
+				return fmt.Errorf("la acción '%s' define 'max_lifetime_seconds' pero no tiene una reversión programada ('revert_command' y 'revert_delay_seconds')", actionName)
+			}
+			if action.MaxLifetimeSeconds < action.RevertDelaySeconds {
+				return fmt.Errorf("la acción '%s' tiene un 'max_lifetime_seconds' (%d) menor que su 'revert_delay_seconds' (%d)", actionName, action.MaxLifetimeSeconds, action.RevertDelaySeconds)
+			}
+		}
+		if action.Acknowledge && cfg.Server.SigningKey == nil {
+			return fmt.Errorf("la acción '%s' tiene 'acknowledge' activado pero no se ha configurado 'server.signing_key_file'", actionName)
+		}
+		if action.ReturnOutput && cfg.Server.SigningKey == nil {
+			return fmt.Errorf("la acción '%s' tiene 'return_output' activado pero no se ha configurado 'server.signing_key_file'", actionName)
+		}
+		if action.MaxOutputBytes < 0 || action.MaxOutputBytes > MaxOutputBytesLimit {
+			return fmt.Errorf("la acción '%s' tiene un 'max_output_bytes' (%d) fuera del rango permitido (0-%d)", actionName, action.MaxOutputBytes, MaxOutputBytesLimit)
+		}
+		if action.ReturnOutput && action.MaxOutputBytes == 0 {
+			action.MaxOutputBytes = DefaultMaxOutputBytes
+		}
+		if err := validateApprovals(actionName, &action, userNames); err != nil {
+			return err
+		}
+		if action.AllowedSchedule != nil {
+			if err := action.AllowedSchedule.compile(); err != nil {
+				return fmt.Errorf("la acción '%s' tiene un 'allowed_schedule' inválido: %w", actionName, err)
+			}
+		}
+		cfg.Actions[actionName] = action
 		if action.RunAsUser != "" {
 			if action.RunAsUser == "root" {
 				return fmt.Errorf("la acción '%s' tiene 'run_as_user' configurado como 'root', lo cual está prohibido por seguridad", actionName)
@@ -182,3 +579,61 @@ func validateConfig(cfg *Config) error {
 
 	return nil
 }
+
+// validateLogging completa los valores por defecto del destino del log y los valida.
+// Sin 'output', el log sigue yendo al archivo histórico para no romper instalaciones existentes.
+func validateLogging(logging *Logging) error {
+	if logging.Output == "" {
+		logging.Output = "file"
+	}
+	if logging.Format == "" {
+		logging.Format = "text"
+	}
+	switch logging.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("el valor de 'logging.format' ('%s') es inválido; debe ser 'text' o 'json'", logging.Format)
+	}
+
+	switch logging.Output {
+	case "stderr":
+	case "file":
+		if logging.File == "" {
+			logging.File = DefaultLogFile
+		}
+	case "syslog":
+		syslog := &logging.Syslog
+		if syslog.Network == "" {
+			syslog.Network = "unixgram"
+		}
+		switch syslog.Network {
+		case "unixgram":
+			if syslog.Address == "" {
+				syslog.Address = DefaultSyslogAddress
+			}
+		case "udp":
+			if syslog.Address == "" {
+				syslog.Address = DefaultSyslogUDPAddress
+			}
+			if _, _, err := net.SplitHostPort(syslog.Address); err != nil {
+				return fmt.Errorf("el campo 'logging.syslog.address' ('%s') no es una dirección host:puerto válida: %w", syslog.Address, err)
+			}
+		default:
+			return fmt.Errorf("el valor de 'logging.syslog.network' ('%s') es inválido; debe ser 'unixgram' o 'udp'", syslog.Network)
+		}
+		if syslog.Facility == "" {
+			syslog.Facility = "daemon"
+		}
+		switch syslog.Facility {
+		case "daemon", "auth", "authpriv", "user", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7":
+		default:
+			return fmt.Errorf("el valor de 'logging.syslog.facility' ('%s') es inválido; use 'daemon', 'auth', 'authpriv', 'user' o 'local0'..'local7'", syslog.Facility)
+		}
+		if syslog.Tag == "" {
+			syslog.Tag = "ghostknockd"
+		}
+	default:
+		return fmt.Errorf("el valor de 'logging.output' ('%s') es inválido; debe ser 'file', 'stderr' o 'syslog'", logging.Output)
+	}
+	return nil
+}
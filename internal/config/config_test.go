@@ -0,0 +1,94 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/your-org/ghostknock/internal/keyfile"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestValidateApprovals(t *testing.T) {
+	users := map[string]struct{}{"ana": {}, "luis": {}, "marta": {}}
+	valid := Action{Command: "true", Acknowledge: true, RequireApprovals: 2, Approvers: []string{"ana", "luis", "marta"}}
+
+	action := valid
+	if err := validateApprovals("lockdown", &action, users); err != nil {
+		t.Fatalf("validateApprovals rechazó una acción válida: %v", err)
+	}
+	if action.ApprovalWindowSeconds != DefaultApprovalWindowSeconds {
+		t.Errorf("approval_window_seconds = %d, se esperaba el valor por defecto %d", action.ApprovalWindowSeconds, DefaultApprovalWindowSeconds)
+	}
+
+	// Cada caso altera la acción válida y debe fallar con un error que lo explique.
+	invalid := map[string]struct {
+		edit func(*Action)
+		want string
+	}{
+		"sin acuse":                 {func(a *Action) { a.Acknowledge = false }, "'acknowledge'"},
+		"aprobador duplicado":       {func(a *Action) { a.Approvers = []string{"ana", "ana", "luis"} }, "duplicado"},
+		"aprobador desconocido":     {func(a *Action) { a.Approvers = []string{"ana", "pedro"} }, "'pedro'"},
+		"pocos aprobadores":         {func(a *Action) { a.RequireApprovals = 4 }, "solo tiene 3"},
+		"aprobadores sin requisito": {func(a *Action) { a.RequireApprovals = 0 }, "'approvers'"},
+		"plazo negativo":            {func(a *Action) { a.ApprovalWindowSeconds = -1 }, "negativo"},
+	}
+	for name, tt := range invalid {
+		action := valid
+		action.Approvers = append([]string(nil), valid.Approvers...)
+		tt.edit(&action)
+		err := validateApprovals("lockdown", &action, users)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, se esperaba uno que mencione %s", name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openSSH, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedOpenSSH, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte("secreta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := keyfile.Encrypt(privateKey, []byte("secreta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// Se aceptan los formatos sin cifrar que produce ghostknock-keygen y ssh-keygen.
+	for _, path := range []string{write("raw", privateKey), write("openssh", pem.EncodeToMemory(openSSH))} {
+		key, err := loadSigningKey(path)
+		if err != nil || !key.Equal(privateKey) {
+			t.Errorf("loadSigningKey(%s) = %v, se esperaba la clave original", filepath.Base(path), err)
+		}
+	}
+	// Las claves cifradas se rechazan con un mensaje que lo explica, en lugar de
+	// un error de tamaño.
+	for _, path := range []string{write("encrypted", encrypted), write("openssh-encrypted", pem.EncodeToMemory(encryptedOpenSSH))} {
+		if _, err := loadSigningKey(path); err == nil || !strings.Contains(err.Error(), "cifrada") {
+			t.Errorf("loadSigningKey(%s) = %v, se esperaba un error de clave cifrada", filepath.Base(path), err)
+		}
+	}
+	if _, err := loadSigningKey(write("short", privateKey[:32])); err == nil {
+		t.Error("loadSigningKey aceptó una clave de tamaño incorrecto")
+	}
+}
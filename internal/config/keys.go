@@ -0,0 +1,151 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// ParsePublicKey decodifica una clave pública ed25519 en Base64 (el formato de
+// ghostknock-keygen) o en el formato de OpenSSH ('ssh-ed25519 AAAA... comentario').
+func ParsePublicKey(value string) (ed25519.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "ssh-") {
+		sshKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("no es una clave pública de OpenSSH válida: %w", err)
+		}
+		return ed25519FromSSH(sshKey)
+	}
+
+	pkBytes, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("no es un Base64 válido: %w", err)
+	}
+	if len(pkBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("tamaño incorrecto: se esperaban %d bytes, tiene %d", ed25519.PublicKeySize, len(pkBytes))
+	}
+	return ed25519.PublicKey(pkBytes), nil
+}
+
+// LoadAuthorizedKeys lee un archivo al estilo de authorized_keys de OpenSSH.
+// Las líneas vacías y los comentarios se ignoran; las opciones al inicio de una
+// línea (from=, command=...) se aceptan pero no se aplican. Solo se admiten
+// claves ssh-ed25519.
+func LoadAuthorizedKeys(path string) ([]ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKeys []ed25519.PublicKey
+	for lineNumber, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		sshKey, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("línea %d de '%s': %w", lineNumber+1, path, err)
+		}
+		publicKey, err := ed25519FromSSH(sshKey)
+		if err != nil {
+			return nil, fmt.Errorf("línea %d de '%s': %w", lineNumber+1, path, err)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	if len(publicKeys) == 0 {
+		return nil, fmt.Errorf("'%s' no contiene ninguna clave", path)
+	}
+	return publicKeys, nil
+}
+
+// ed25519FromSSH extrae la clave ed25519 de una clave pública de OpenSSH.
+func ed25519FromSSH(sshKey ssh.PublicKey) (ed25519.PublicKey, error) {
+	if sshKey.Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("tipo de clave '%s' no soportado: GhostKnock solo admite ssh-ed25519", sshKey.Type())
+	}
+	cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, errors.New("no se pudo extraer la clave ed25519")
+	}
+	publicKey, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("no se pudo extraer la clave ed25519")
+	}
+	return publicKey, nil
+}
+
+// ErrUnknownCA indica que el certificado está firmado por una CA que no figura en 'trusted_ca_keys'.
+var ErrUnknownCA = errors.New("el certificado está firmado por una CA desconocida")
+
+// ErrSubjectConflict indica que el titular de un certificado coincide con un
+// usuario o aprobador de la configuración. Se rechaza para que un certificado
+// no pueda suplantarlo: cooldowns, reversiones y aprobaciones se asocian al nombre.
+var ErrSubjectConflict = errors.New("el titular del certificado coincide con un usuario o aprobador de la configuración")
+
+// CertificateUser verifica la firma de la CA de un certificado y deriva de él
+// un usuario equivalente a una entrada de 'users'. Las acciones del certificado
+// que no existen en la configuración se ignoran. El periodo de validez se copia
+// en Validity, así que el llamante debe comprobarlo como el de cualquier usuario.
+func (c *Config) CertificateUser(cert *protocol.Certificate) (*User, error) {
+	caKeyID, err := cert.CAKey()
+	if err != nil {
+		return nil, err
+	}
+	caKey, ok := c.Server.CAKeys[caKeyID]
+	if !ok {
+		return nil, ErrUnknownCA
+	}
+	if !cert.Verify(caKey) {
+		return nil, errors.New("la firma de la CA del certificado no es válida")
+	}
+	if c.isReservedName(cert.Subject) {
+		return nil, ErrSubjectConflict
+	}
+
+	user := &User{
+		Name:        cert.Subject,
+		SourceIPs:   cert.SourceIPs,
+		Validity:    Validity{NotBefore: cert.ValidFrom(), NotAfter: cert.ValidUntil()},
+		PublicKeys:  []ed25519.PublicKey{ed25519.PublicKey(cert.PublicKey)},
+		Certificate: cert,
+	}
+	for _, actionID := range cert.Actions {
+		if _, exists := c.Actions[actionID]; exists {
+			user.AllowedActions = append(user.AllowedActions, actionID)
+		}
+	}
+	for _, ipStr := range cert.SourceIPs {
+		_, cidr, err := net.ParseCIDR(ipStr)
+		if err != nil {
+			return nil, fmt.Errorf("el certificado tiene una IP/CIDR inválida: '%s'", ipStr)
+		}
+		user.SourceCIDRs = append(user.SourceCIDRs, cidr)
+	}
+	return user, nil
+}
+
+// isReservedName indica si el nombre pertenece a un usuario o aprobador de la configuración.
+func (c *Config) isReservedName(name string) bool {
+	for i := range c.Users {
+		if c.Users[i].Name == name {
+			return true
+		}
+	}
+	for _, action := range c.Actions {
+		if action.IsApprover(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// authorizedKey devuelve la clave en el formato de una línea de authorized_keys.
+func authorizedKey(t *testing.T, publicKey any) string {
+	t.Helper()
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey)))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"Base64", base64.StdEncoding.EncodeToString(publicKey), false},
+		{"Base64 con espacios", "  " + base64.StdEncoding.EncodeToString(publicKey) + "\n", false},
+		{"OpenSSH", authorizedKey(t, publicKey), false},
+		{"OpenSSH con comentario", authorizedKey(t, publicKey) + " alice@portátil", false},
+		{"OpenSSH ECDSA", authorizedKey(t, &ecdsaKey.PublicKey), true},
+		{"Base64 de tamaño incorrecto", base64.StdEncoding.EncodeToString(publicKey[:16]), true},
+		{"no es Base64", "PEGAR_CLAVE_PUBLICA_AQUI", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePublicKey(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(publicKey) {
+				t.Error("ParsePublicKey() devolvió otra clave")
+			}
+		})
+	}
+}
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	first, _, _ := ed25519.GenerateKey(nil)
+	second, _, _ := ed25519.GenerateKey(nil)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "authorized_keys")
+	content := "# claves del equipo\n\n" +
+		authorizedKey(t, first) + " alice\n" +
+		`from="10.0.0.0/8" ` + authorizedKey(t, second) + " bob\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAuthorizedKeys(path)
+	if err != nil {
+		t.Fatalf("LoadAuthorizedKeys: %v", err)
+	}
+	if len(keys) != 2 || !keys[0].Equal(first) || !keys[1].Equal(second) {
+		t.Fatalf("LoadAuthorizedKeys() devolvió %d claves, se esperaban las 2 del archivo en orden", len(keys))
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("# sin claves\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuthorizedKeys(empty); err == nil {
+		t.Error("LoadAuthorizedKeys() aceptó un archivo sin claves")
+	}
+
+	broken := filepath.Join(dir, "broken")
+	if err := os.WriteFile(broken, []byte(authorizedKey(t, first)+"\nbasura\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuthorizedKeys(broken); err == nil || !strings.Contains(err.Error(), "línea 2") {
+		t.Errorf("LoadAuthorizedKeys() error = %v, se esperaba un error en la línea 2", err)
+	}
+}
+
+func TestCertificateUser(t *testing.T) {
+	caPublicKey, caKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherCAKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Server:  Server{CAKeys: map[[protocol.KeyIDSize]byte]ed25519.PublicKey{protocol.KeyIDFromPublicKey(caPublicKey): caPublicKey}},
+		Actions: map[string]Action{"open-ssh": {Command: "true"}},
+	}
+	// issue firma con signer un certificado para holderKey y lo devuelve ya
+	// decodificado, como lo recibe el demonio.
+	issue := func(signer ed25519.PrivateKey, actions, sourceIPs []string) *protocol.Certificate {
+		t.Helper()
+		signed, err := protocol.NewCertificate("alice@ci", holderKey, actions, sourceIPs, time.Now().Add(-time.Minute), time.Hour).Sign(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := protocol.ParseCertificate(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	cert := issue(caKey, []string{"open-ssh", "no-existe"}, []string{"10.0.0.0/8"})
+	user, err := cfg.CertificateUser(cert)
+	if err != nil {
+		t.Fatalf("CertificateUser: %v", err)
+	}
+	if user.Name != "alice@ci" || len(user.PublicKeys) != 1 || !user.PublicKeys[0].Equal(holderKey) {
+		t.Errorf("usuario = %q con claves %x, se esperaba alice@ci con la clave del titular", user.Name, user.PublicKeys)
+	}
+	// Las acciones que la configuración no define se descartan en lugar de fallar.
+	if len(user.AllowedActions) != 1 || user.AllowedActions[0] != "open-ssh" {
+		t.Errorf("acciones = %v, se esperaba [open-ssh]", user.AllowedActions)
+	}
+	if len(user.SourceCIDRs) != 1 || !user.SourceCIDRs[0].Contains([]byte{10, 1, 2, 3}) {
+		t.Errorf("orígenes = %v, se esperaba 10.0.0.0/8", user.SourceCIDRs)
+	}
+	// El periodo de validez no lo comprueba CertificateUser: se traslada al usuario.
+	if !user.Validity.NotAfter.Equal(cert.ValidUntil()) || !user.Validity.Contains(time.Now()) || user.Validity.Contains(cert.ValidUntil().Add(time.Second)) {
+		t.Errorf("validez = %+v, se esperaba la del certificado", user.Validity)
+	}
+
+	if _, err := cfg.CertificateUser(issue(otherCAKey, []string{"open-ssh"}, nil)); !errors.Is(err, ErrUnknownCA) {
+		t.Errorf("CA desconocida: error = %v, se esperaba %v", err, ErrUnknownCA)
+	}
+	if _, err := cfg.CertificateUser(issue(caKey, []string{"open-ssh"}, []string{"10.0.0.1"})); err == nil {
+		t.Error("CertificateUser() aceptó un origen que no es un CIDR")
+	}
+
+	// Un certificado no puede hacerse pasar por un usuario ni por un aprobador configurados.
+	cert = issue(caKey, []string{"open-ssh"}, nil)
+	cfg.Users = []User{{Name: "alice@ci"}}
+	if _, err := cfg.CertificateUser(cert); !errors.Is(err, ErrSubjectConflict) {
+		t.Errorf("titular igual a un usuario: error = %v, se esperaba %v", err, ErrSubjectConflict)
+	}
+	cfg.Users = nil
+	cfg.Actions["deploy"] = Action{Command: "true", RequireApprovals: 1, Approvers: []string{"alice@ci"}}
+	if _, err := cfg.CertificateUser(cert); !errors.Is(err, ErrSubjectConflict) {
+		t.Errorf("titular igual a un aprobador: error = %v, se esperaba %v", err, ErrSubjectConflict)
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule es una franja horaria semanal opcional ('allowed_schedule') fuera de
+// la cual se rechazan los knocks de un usuario o de una acción.
+//
+//	allowed_schedule:
+//	  days: ["mon-fri"]
+//	  hours: ["09:00-14:00", "15:00-18:00"]
+//	  timezone: "Europe/Madrid"
+//
+// Sin 'days' se permiten todos los días y sin 'hours', el día completo. Una
+// franja que cruza la medianoche ("22:00-06:00") pertenece al día en que empieza.
+type Schedule struct {
+	Days     []string `yaml:"days,omitempty"`
+	Hours    []string `yaml:"hours,omitempty"`
+	Timezone string   `yaml:"timezone,omitempty"`
+
+	days     [7]bool // Indexado por time.Weekday.
+	ranges   []minuteRange
+	location *time.Location
+}
+
+// minuteRange es una franja en minutos desde la medianoche, [start, end).
+// Si end <= start, la franja termina al día siguiente.
+type minuteRange struct {
+	start, end int
+}
+
+const minutesPerDay = 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// compile valida la franja y prepara su forma interna.
+func (s *Schedule) compile() error {
+	if len(s.Days) == 0 && len(s.Hours) == 0 {
+		return errors.New("debe indicar 'days', 'hours' o ambos")
+	}
+
+	if len(s.Days) == 0 {
+		for day := range s.days {
+			s.days[day] = true
+		}
+	}
+	for _, entry := range s.Days {
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(entry)), "-")
+		if !isRange {
+			last = first
+		}
+		from, ok := weekdays[first]
+		if !ok {
+			return fmt.Errorf("día de la semana inválido '%s' (use mon, tue, wed, thu, fri, sat, sun o un rango como 'mon-fri')", entry)
+		}
+		to, ok := weekdays[last]
+		if !ok {
+			return fmt.Errorf("día de la semana inválido '%s' (use mon, tue, wed, thu, fri, sat, sun o un rango como 'mon-fri')", entry)
+		}
+		// Los rangos pueden dar la vuelta a la semana, como "sat-sun" o "fri-mon".
+		for day := from; ; day = (day + 1) % 7 {
+			s.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	for _, entry := range s.Hours {
+		start, end, ok := strings.Cut(strings.TrimSpace(entry), "-")
+		if !ok {
+			return fmt.Errorf("franja horaria inválida '%s' (formato HH:MM-HH:MM)", entry)
+		}
+		startMinute, err := parseClock(start)
+		if err != nil || startMinute == minutesPerDay {
+			return fmt.Errorf("franja horaria inválida '%s': hora de inicio incorrecta", entry)
+		}
+		endMinute, err := parseClock(end)
+		if err != nil {
+			return fmt.Errorf("franja horaria inválida '%s': hora de fin incorrecta", entry)
+		}
+		if startMinute == endMinute {
+			return fmt.Errorf("franja horaria inválida '%s': el inicio y el fin coinciden", entry)
+		}
+		s.ranges = append(s.ranges, minuteRange{start: startMinute, end: endMinute % minutesPerDay})
+	}
+
+	s.location = time.Local
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("zona horaria inválida '%s': %w", s.Timezone, err)
+		}
+		s.location = location
+	}
+	return nil
+}
+
+// parseClock convierte "HH:MM" en minutos desde la medianoche. Admite "24:00"
+// como fin del día.
+func parseClock(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "24:00" {
+		return minutesPerDay, nil
+	}
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// Allows indica si el instante t cae dentro de la franja. Una franja nil no
+// restringe nada.
+func (s *Schedule) Allows(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	local := t.In(s.location)
+	day := local.Weekday()
+	if len(s.ranges) == 0 {
+		return s.days[day]
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	previousDay := (day + 6) % 7
+	for _, r := range s.ranges {
+		if r.start < r.end {
+			if s.days[day] && minute >= r.start && minute < r.end {
+				return true
+			}
+			continue
+		}
+		// La franja cruza la medianoche: la parte de la madrugada es del día anterior.
+		if s.days[day] && minute >= r.start {
+			return true
+		}
+		if s.days[previousDay] && minute < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// String describe la franja para los logs, ej. "mon-fri 09:00-18:00 Europe/Madrid".
+func (s *Schedule) String() string {
+	if s == nil {
+		return ""
+	}
+	parts := []string{strings.Join(s.Days, ","), strings.Join(s.Hours, ",")}
+	if s.location != nil {
+		parts = append(parts, s.location.String())
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
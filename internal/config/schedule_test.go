@@ -0,0 +1,130 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// weekAt devuelve el instante UTC de la primera semana de 2024 en el día
+// (1 = lunes ... 7 = domingo) y la hora dados.
+func weekAt(t *testing.T, day int, clock string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-01-01 es lunes.
+	return time.Date(2024, 1, day, parsed.Hour(), parsed.Minute(), 0, 0, time.UTC)
+}
+
+const (
+	monday   = 1
+	tuesday  = 2
+	friday   = 5
+	saturday = 6
+	sunday   = 7
+)
+
+// checkSchedule compila la franja y comprueba que permite exactamente los
+// instantes de allowed y ninguno de denied.
+func checkSchedule(t *testing.T, schedule Schedule, allowed, denied []time.Time) {
+	t.Helper()
+	if err := schedule.compile(); err != nil {
+		t.Fatalf("compile(%+v): %v", schedule, err)
+	}
+	for _, at := range allowed {
+		if !schedule.Allows(at) {
+			t.Errorf("%s no permite %s", schedule.String(), at.Format("Mon 15:04 MST"))
+		}
+	}
+	for _, at := range denied {
+		if schedule.Allows(at) {
+			t.Errorf("%s permite %s", schedule.String(), at.Format("Mon 15:04 MST"))
+		}
+	}
+}
+
+func TestScheduleDays(t *testing.T) {
+	checkSchedule(t, Schedule{Days: []string{"mon-fri"}},
+		[]time.Time{weekAt(t, monday, "03:00"), weekAt(t, friday, "23:59")},
+		[]time.Time{weekAt(t, saturday, "12:00"), weekAt(t, sunday, "00:00")})
+
+	// Un rango puede dar la vuelta a la semana.
+	checkSchedule(t, Schedule{Days: []string{"fri-mon"}},
+		[]time.Time{weekAt(t, friday, "12:00"), weekAt(t, sunday, "12:00"), weekAt(t, monday, "12:00")},
+		[]time.Time{weekAt(t, tuesday, "12:00")})
+
+	// Los nombres no distinguen mayúsculas ni espacios.
+	checkSchedule(t, Schedule{Days: []string{" SAT ", "Sun"}},
+		[]time.Time{weekAt(t, saturday, "12:00"), weekAt(t, sunday, "12:00")},
+		[]time.Time{weekAt(t, monday, "12:00")})
+}
+
+func TestScheduleHours(t *testing.T) {
+	// El fin de la franja es exclusivo.
+	checkSchedule(t, Schedule{Hours: []string{"09:00-14:00", "15:00-18:00"}},
+		[]time.Time{weekAt(t, tuesday, "09:00"), weekAt(t, tuesday, "13:59"), weekAt(t, saturday, "15:30")},
+		[]time.Time{weekAt(t, tuesday, "08:59"), weekAt(t, tuesday, "14:30"), weekAt(t, tuesday, "18:00")})
+
+	// 24:00 cierra el día sin alcanzar la medianoche siguiente.
+	checkSchedule(t, Schedule{Hours: []string{"20:00-24:00"}},
+		[]time.Time{weekAt(t, tuesday, "23:59")},
+		[]time.Time{weekAt(t, tuesday, "00:00")})
+	checkSchedule(t, Schedule{Hours: []string{"00:00-24:00"}},
+		[]time.Time{weekAt(t, tuesday, "00:00"), weekAt(t, tuesday, "23:59")},
+		nil)
+}
+
+// Una franja nocturna pertenece al día en que empieza: la madrugada del sábado
+// es parte del viernes y la del lunes, del domingo.
+func TestScheduleOvernight(t *testing.T) {
+	checkSchedule(t, Schedule{Hours: []string{"22:00-06:00"}},
+		[]time.Time{weekAt(t, tuesday, "23:00"), weekAt(t, tuesday, "05:59")},
+		[]time.Time{weekAt(t, tuesday, "06:00"), weekAt(t, tuesday, "12:00")})
+
+	checkSchedule(t, Schedule{Days: []string{"mon-fri"}, Hours: []string{"22:00-06:00"}},
+		[]time.Time{weekAt(t, monday, "22:30"), weekAt(t, saturday, "02:00")},
+		[]time.Time{weekAt(t, monday, "02:00"), weekAt(t, saturday, "23:00")})
+
+	checkSchedule(t, Schedule{Days: []string{"fri"}, Hours: []string{"22:00-06:00"}},
+		[]time.Time{weekAt(t, saturday, "05:00")},
+		[]time.Time{weekAt(t, friday, "02:00")})
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	// En enero Madrid está en UTC+1: las 08:30 UTC son las 09:30 allí.
+	checkSchedule(t, Schedule{Hours: []string{"09:00-18:00"}, Timezone: "Europe/Madrid"},
+		[]time.Time{weekAt(t, tuesday, "08:30")},
+		[]time.Time{weekAt(t, tuesday, "17:30")})
+
+	// Las 23:30 UTC del domingo ya son lunes en Madrid.
+	checkSchedule(t, Schedule{Days: []string{"mon"}, Timezone: "Europe/Madrid"},
+		[]time.Time{weekAt(t, sunday, "23:30")},
+		[]time.Time{weekAt(t, monday, "23:30")})
+}
+
+func TestScheduleNilAllowsEverything(t *testing.T) {
+	var schedule *Schedule
+	if !schedule.Allows(weekAt(t, saturday, "03:00")) {
+		t.Error("una franja nil debe permitirlo todo")
+	}
+}
+
+func TestScheduleCompileRejects(t *testing.T) {
+	invalid := map[string]Schedule{
+		"vacía":                    {},
+		"día desconocido":          {Days: []string{"lunes"}},
+		"rango de días inválido":   {Days: []string{"mon-xyz"}},
+		"franja sin guion":         {Hours: []string{"09:00"}},
+		"hora inválida":            {Hours: []string{"25:00-26:00"}},
+		"inicio a las 24:00":       {Hours: []string{"24:00-06:00"}},
+		"inicio igual al fin":      {Hours: []string{"09:00-09:00"}},
+		"franja 00:00-00:00":       {Hours: []string{"00:00-00:00"}},
+		"zona horaria desconocida": {Days: []string{"mon"}, Timezone: "Marte/Olympus"},
+	}
+	for name, schedule := range invalid {
+		if err := schedule.compile(); err == nil {
+			t.Errorf("%s: compile() aceptó una franja inválida", name)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Call envía una petición al socket de control y devuelve la respuesta del demonio.
+func Call(path string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", path, connTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo conectar al socket de control '%s': %w", path, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("no se pudo enviar la petición: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("respuesta inválida del demonio: %w", err)
+	}
+	if !resp.OK {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,88 @@
+// El paquete control implementa el socket de administración local de ghostknockd:
+// un socket Unix accesible solo por root que atiende peticiones JSON, una por
+// conexión, para inspeccionar y manipular el estado del demonio en ejecución.
+package control
+
+import (
+	"time"
+)
+
+// Comandos soportados por el socket de control.
+const (
+	CmdStatus        = "status"
+	CmdRunRevert     = "revert-run"
+	CmdCancelRevert  = "revert-cancel"
+	CmdClearCooldown = "cooldown-clear"
+	CmdReload        = "reload"
+)
+
+// Request es una petición al socket de control.
+type Request struct {
+	Command  string `json:"command"`
+	RevertID string `json:"revert_id,omitempty"`
+	User     string `json:"user,omitempty"`
+	ActionID string `json:"action_id,omitempty"`
+}
+
+// Response es la respuesta del demonio a una petición.
+type Response struct {
+	OK     bool    `json:"ok"`
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status es una instantánea del estado interno del demonio.
+type Status struct {
+	StartedAt      time.Time       `json:"started_at"`
+	ConfigLoadedAt time.Time       `json:"config_loaded_at"`
+	Reverts        []Revert        `json:"reverts"`
+	Cooldowns      []Cooldown      `json:"cooldowns"`
+	RateLimits     []RateLimitedIP `json:"rate_limits"`
+	Approvals      []Approval      `json:"approvals"`
+}
+
+// Revert describe una reversión pendiente.
+type Revert struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	ActionID  string    `json:"action_id"`
+	SourceIP  string    `json:"source_ip"`
+	CreatedAt time.Time `json:"created_at"`
+	DueAt     time.Time `json:"due_at"`
+}
+
+// Cooldown describe un cooldown activo de una acción para un usuario.
+type Cooldown struct {
+	User          string    `json:"user"`
+	ActionID      string    `json:"action_id"`
+	LastExecution time.Time `json:"last_execution"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// Approval describe una solicitud pendiente de aprobación.
+type Approval struct {
+	RequestID string    `json:"request_id"`
+	User      string    `json:"user"`
+	ActionID  string    `json:"action_id"`
+	SourceIP  string    `json:"source_ip"`
+	Approvers []string  `json:"approvers"`
+	Required  int       `json:"required"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RateLimitedIP describe el estado del limitador de una IP de origen.
+type RateLimitedIP struct {
+	SourceIP string    `json:"source_ip"`
+	Tokens   float64   `json:"tokens"`
+	Limited  bool      `json:"limited"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Handler es implementado por el demonio para atender las peticiones.
+type Handler interface {
+	Status() Status
+	RunRevert(id string) error
+	CancelRevert(id string) error
+	ClearCooldown(user, actionID string) error
+	Reload() error
+}
@@ -0,0 +1,34 @@
+package control
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPeer comprueba con SO_PEERCRED que el proceso al otro lado del socket sea
+// root o el mismo usuario que ejecuta el demonio.
+func checkPeer(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("no se pudieron obtener las credenciales del cliente: %w", credErr)
+	}
+
+	if cred.Uid != 0 && int(cred.Uid) != os.Geteuid() {
+		return fmt.Errorf("el usuario con UID %d no está autorizado (pid %d)", cred.Uid, cred.Pid)
+	}
+	return nil
+}
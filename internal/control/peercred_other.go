@@ -0,0 +1,13 @@
+//go:build !linux
+
+package control
+
+import (
+	"errors"
+	"net"
+)
+
+// checkPeer rechaza todas las conexiones en plataformas sin SO_PEERCRED.
+func checkPeer(conn *net.UnixConn) error {
+	return errors.New("la verificación de credenciales del socket de control solo está soportada en Linux")
+}
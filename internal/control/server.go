@@ -0,0 +1,110 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	socketPerms    = 0600
+	socketDirPerms = 0700
+	connTimeout    = 10 * time.Second
+	maxRequestSize = 4096
+)
+
+// Serve escucha en el socket Unix indicado hasta que se cancele el contexto.
+// Solo se atienden conexiones de root o del mismo usuario que ejecuta el demonio.
+func Serve(ctx context.Context, path string, handler Handler) error {
+	if err := os.MkdirAll(filepath.Dir(path), socketDirPerms); err != nil {
+		return fmt.Errorf("no se pudo crear el directorio del socket de control: %w", err)
+	}
+	// Un socket huérfano de una ejecución anterior impediría el bind.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no se pudo eliminar el socket de control anterior: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("no se pudo escuchar en el socket de control '%s': %w", path, err)
+	}
+	if err := os.Chmod(path, socketPerms); err != nil {
+		ln.Close()
+		return fmt.Errorf("no se pudieron restringir los permisos del socket de control: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	defer os.Remove(path)
+
+	slog.Info("Socket de control activo", "path", path)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			slog.Warn("Error aceptando conexión en el socket de control", "error", err)
+			continue
+		}
+		go serveConn(conn.(*net.UnixConn), handler)
+	}
+}
+
+func serveConn(conn *net.UnixConn, handler Handler) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if err := checkPeer(conn); err != nil {
+		slog.Warn("Conexión rechazada en el socket de control", "reason", "unauthorized_peer", "error", err)
+		return
+	}
+
+	var req Request
+	// El límite evita que un cliente mantenga al demonio leyendo sin fin.
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&req); err != nil {
+		writeResponse(conn, Response{Error: fmt.Sprintf("petición inválida: %v", err)})
+		return
+	}
+
+	slog.Info("Petición recibida en el socket de control", "command", req.Command, "revert_id", req.RevertID, "user", req.User, "action_id", req.ActionID)
+	writeResponse(conn, dispatch(req, handler))
+}
+
+func dispatch(req Request, handler Handler) Response {
+	var err error
+	switch req.Command {
+	case CmdStatus:
+		status := handler.Status()
+		return Response{OK: true, Status: &status}
+	case CmdRunRevert:
+		err = handler.RunRevert(req.RevertID)
+	case CmdCancelRevert:
+		err = handler.CancelRevert(req.RevertID)
+	case CmdClearCooldown:
+		err = handler.ClearCooldown(req.User, req.ActionID)
+	case CmdReload:
+		err = handler.Reload()
+	default:
+		err = fmt.Errorf("comando desconocido: '%s'", req.Command)
+	}
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{OK: true}
+}
+
+func writeResponse(conn net.Conn, resp Response) {
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		slog.Debug("No se pudo enviar la respuesta del socket de control", "error", err)
+	}
+}
@@ -16,7 +16,9 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/your-org/ghostknock/internal/audit"
 	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/metrics"
 )
 
 // safeParamRegex define la lista blanca de caracteres permitidos en los parámetros.
@@ -25,56 +27,144 @@ import (
 // y navegación de directorios (barras).
 var safeParamRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
 
+// logOutputLimit es la salida que se captura, por flujo, de los comandos que no
+// la devuelven al cliente: solo se usa para el log y los mensajes de error.
+const logOutputLimit = config.DefaultMaxOutputBytes
+
+// Request agrupa los datos de una ejecución ya autorizada por el demonio.
+type Request struct {
+	ActionID string
+	User     string
+	Action   config.Action
+	SourceIP net.IP
+	Params   map[string]string
+}
+
 // Execute procesa una acción, valida sus parámetros, la ejecuta y programa su reversión.
 // Ahora acepta un mapa de parámetros sanitizados.
-func Execute(action config.Action, sourceIP net.IP, params map[string]string) error {
-	slog.Debug("Ejecutando acción", "source_ip", sourceIP.String())
+func Execute(req Request, reverts *Scheduler) (Result, error) {
+	slog.Debug("Ejecutando acción", "source_ip", req.SourceIP.String())
 
 	// Ejecutar el comando principal pasando los parámetros.
-	if err := runCommand("main", action.Command, action.TimeoutSeconds, action.RunAsUser, sourceIP, params); err != nil {
-		return fmt.Errorf("falló la ejecución del comando principal: %w", err)
+	outputLimit := logOutputLimit
+	if req.Action.ReturnOutput {
+		outputLimit = max(req.Action.MaxOutputBytes, logOutputLimit)
+	}
+	result, err := runCommand("main", req.ActionID, req.Action.Command, req.Action.TimeoutSeconds, req.Action.RunAsUser, req.SourceIP, req.Params, outputLimit)
+	auditCommand(audit.Event{
+		User:        req.User,
+		ActionID:    req.ActionID,
+		SourceIP:    req.SourceIP.String(),
+		CommandType: "main",
+		RunAsUser:   req.Action.RunAsUser,
+	}, result, err)
+	if req.Action.ReturnOutput {
+		result.limitOutput(req.Action.MaxOutputBytes)
+	} else {
+		result.Stdout, result.Stderr = nil, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("falló la ejecución del comando principal: %w", err)
 	}
 
 	// Si hay un comando de reversión y un retardo, programarlo.
-	if action.RevertCommand != "" && action.RevertDelaySeconds > 0 {
-		go scheduleRevert(action, sourceIP, params)
+	if req.Action.RevertCommand != "" && req.Action.RevertDelaySeconds > 0 {
+		reverts.Schedule(req)
 	}
 
-	return nil
+	return result, nil
 }
 
-// scheduleRevert espera el tiempo especificado y luego ejecuta el comando de reversión.
-func scheduleRevert(action config.Action, sourceIP net.IP, params map[string]string) {
-	delay := time.Duration(action.RevertDelaySeconds) * time.Second
-	slog.Info(
-		"Programando reversión de acción",
-		"source_ip", sourceIP.String(),
-		"delay", delay.String(),
-	)
-	time.Sleep(delay)
+// Result describe el resultado de un comando. Command queda vacío si el comando
+// no llegó a lanzarse (parámetros inválidos, plantilla rota...).
+type Result struct {
+	Command  string
+	Status   string // success, failure o timeout
+	ExitCode int
+	Duration time.Duration
+	// Stdout y Stderr solo se devuelven para las acciones con 'return_output',
+	// recortados a 'max_output_bytes' entre ambos.
+	Stdout    []byte
+	Stderr    []byte
+	Truncated bool
+}
+
+// cappedBuffer guarda solo los primeros limit bytes escritos y descarta el
+// resto sin fallar, para que un comando muy verboso no agote la memoria del
+// demonio ni se bloquee al escribir.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
 
-	slog.Info("Ejecutando reversión", "source_ip", sourceIP.String())
-	// La reversión también recibe los parámetros (ej. para cerrar el puerto a una IP específica enviada como param).
-	if err := runCommand("revert", action.RevertCommand, action.TimeoutSeconds, action.RunAsUser, sourceIP, params); err != nil {
-		slog.Error(
-			"Falló la ejecución del comando de reversión",
-			"source_ip", sourceIP.String(),
-			"error", err,
-		)
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	room := c.limit - c.buf.Len()
+	if len(p) > room {
+		c.truncated = true
+		if room <= 0 {
+			return len(p), nil
+		}
+		c.buf.Write(p[:room])
+		return len(p), nil
 	}
+	c.buf.Write(p)
+	return len(p), nil
+}
+
+// limitOutput recorta la salida capturada a maxBytes en total, dando prioridad a stdout.
+func (r *Result) limitOutput(maxBytes int) {
+	if len(r.Stdout) > maxBytes {
+		r.Stdout = r.Stdout[:maxBytes]
+		r.Truncated = true
+	}
+	if remaining := maxBytes - len(r.Stdout); len(r.Stderr) > remaining {
+		r.Stderr = r.Stderr[:remaining]
+		r.Truncated = true
+	}
+}
+
+// auditCommand registra en la auditoría el resultado de un comando. Si el
+// comando no llegó a lanzarse (parámetros inválidos, plantilla rota...), se
+// registra como rechazado.
+func auditCommand(ev audit.Event, result Result, err error) {
+	if result.Command == "" {
+		ev.Event = audit.EventCommandRejected
+		ev.Error = err.Error()
+		audit.Record(ev)
+		return
+	}
+
+	ev.Event = audit.EventCommandExecuted
+	ev.Command = result.Command
+	ev.Status = result.Status
+	ev.ExitCode = audit.Int(result.ExitCode)
+	ev.DurationMS = audit.Duration(result.Duration)
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	audit.Record(ev)
 }
 
 // runCommand es el núcleo de la ejecución segura.
-func runCommand(commandType, commandTemplate string, timeoutSeconds int, runAsUser string, sourceIP net.IP, params map[string]string) error {
+// Stdout y stderr se capturan, cada uno, hasta outputLimit bytes.
+func runCommand(commandType, actionID, commandTemplate string, timeoutSeconds int, runAsUser string, sourceIP net.IP, params map[string]string, outputLimit int) (result Result, err error) {
+	// Un comando que no llega a lanzarse también cuenta, como "rejected".
+	defer func() {
+		if result.Command == "" {
+			metrics.CommandsExecuted.WithLabelValues(commandType, actionID, "rejected", "-1").Inc()
+		}
+	}()
+
 	// 1. VALIDACIÓN DE SEGURIDAD DE PARÁMETROS (Sanitización Estricta)
 	if len(params) > 0 {
 		for key, value := range params {
 			if !safeParamRegex.MatchString(value) {
-				return fmt.Errorf("SEGURIDAD: El valor del parámetro '%s' contiene caracteres inválidos. Solo se permiten [a-zA-Z0-9._-]", key)
+				return Result{}, fmt.Errorf("SEGURIDAD: El valor del parámetro '%s' contiene caracteres inválidos. Solo se permiten [a-zA-Z0-9._-]", key)
 			}
 			// Validación redundante pero explícita contra path traversal relativo.
 			if value == ".." {
-				return fmt.Errorf("SEGURIDAD: Uso de '..' no permitido en parámetros")
+				return Result{}, fmt.Errorf("SEGURIDAD: Uso de '..' no permitido en parámetros")
 			}
 		}
 	}
@@ -90,12 +180,12 @@ func runCommand(commandType, commandTemplate string, timeoutSeconds int, runAsUs
 
 	tmpl, err := template.New("cmd").Parse(commandTemplate)
 	if err != nil {
-		return fmt.Errorf("error interno al parsear la plantilla de comando: %w", err)
+		return Result{}, fmt.Errorf("error interno al parsear la plantilla de comando: %w", err)
 	}
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, templateData); err != nil {
-		return fmt.Errorf("error interno al ejecutar la plantilla de comando: %w", err)
+		return Result{}, fmt.Errorf("error interno al ejecutar la plantilla de comando: %w", err)
 	}
 	finalCommand := buf.String()
 
@@ -109,25 +199,26 @@ func runCommand(commandType, commandTemplate string, timeoutSeconds int, runAsUs
 	}
 
 	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", finalCommand)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	stdout := &cappedBuffer{limit: outputLimit}
+	stderr := &cappedBuffer{limit: outputLimit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	// --- LÓGICA DE EJECUCIÓN CON PRIVILEGIOS REDUCIDOS ---
 	if runAsUser != "" {
 		u, err := user.Lookup(runAsUser)
 		if err != nil {
-			return fmt.Errorf("error crítico en tiempo de ejecución: no se pudo encontrar el usuario '%s': %w", runAsUser, err)
+			return Result{}, fmt.Errorf("error crítico en tiempo de ejecución: no se pudo encontrar el usuario '%s': %w", runAsUser, err)
 		}
 
 		uid, err := strconv.ParseUint(u.Uid, 10, 32)
 		if err != nil {
-			return fmt.Errorf("no se pudo parsear el UID '%s' para el usuario '%s': %w", u.Uid, runAsUser, err)
+			return Result{}, fmt.Errorf("no se pudo parsear el UID '%s' para el usuario '%s': %w", u.Uid, runAsUser, err)
 		}
 
 		gid, err := strconv.ParseUint(u.Gid, 10, 32)
 		if err != nil {
-			return fmt.Errorf("no se pudo parsear el GID '%s' para el usuario '%s': %w", u.Gid, runAsUser, err)
+			return Result{}, fmt.Errorf("no se pudo parsear el GID '%s' para el usuario '%s': %w", u.Gid, runAsUser, err)
 		}
 
 		cmd.SysProcAttr = &syscall.SysProcAttr{}
@@ -143,13 +234,41 @@ func runCommand(commandType, commandTemplate string, timeoutSeconds int, runAsUs
 		"source_ip", sourceIP.String(),
 	)
 
+	startedAt := time.Now()
 	err = cmd.Run()
+	duration := time.Since(startedAt)
+
+	// El código de salida es -1 si el proceso no llegó a terminar por sí mismo (timeout, señal o fallo al arrancar).
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	status := "success"
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status = "timeout"
+	case err != nil:
+		status = "failure"
+	}
+	metrics.CommandDuration.WithLabelValues(commandType, actionID).Observe(duration.Seconds())
+	metrics.CommandsExecuted.WithLabelValues(commandType, actionID, status, strconv.Itoa(exitCode)).Inc()
+
+	result = Result{
+		Command:  finalCommand,
+		Status:   status,
+		ExitCode: exitCode,
+		Duration: duration,
+		Stdout:   stdout.buf.Bytes(),
+		Stderr:   stderr.buf.Bytes(),
+		// La salida recortada durante la captura también cuenta como truncada.
+		Truncated: stdout.truncated || stderr.truncated,
+	}
 
-	if stdout.Len() > 0 {
-		slog.Debug("Comando ejecutado (stdout)", "type", commandType, "output", stdout.String())
+	if stdout.buf.Len() > 0 {
+		slog.Debug("Comando ejecutado (stdout)", "type", commandType, "output", stdout.buf.String(), "truncated", stdout.truncated)
 	}
-	if stderr.Len() > 0 {
-		slog.Warn("Comando ejecutado (stderr)", "type", commandType, "output", stderr.String())
+	if stderr.buf.Len() > 0 {
+		slog.Warn("Comando ejecutado (stderr)", "type", commandType, "output", stderr.buf.String(), "truncated", stderr.truncated)
 	}
 
 	if err != nil {
@@ -159,10 +278,10 @@ func runCommand(commandType, commandTemplate string, timeoutSeconds int, runAsUs
 				"timeout_seconds", timeoutSeconds,
 				"command", finalCommand,
 			)
-			return fmt.Errorf("el comando excedió el timeout de %d segundos", timeoutSeconds)
+			return result, fmt.Errorf("el comando excedió el timeout de %d segundos", timeoutSeconds)
 		}
-		return fmt.Errorf("el comando falló: %w. Stderr: %s", err, stderr.String())
+		return result, fmt.Errorf("el comando falló: %w. Stderr: %s", err, stderr.buf.String())
 	}
 
-	return nil
+	return result, nil
 }
@@ -0,0 +1,39 @@
+package executor
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	buf := &cappedBuffer{limit: 8}
+	for _, chunk := range []string{"abc", "defgh", "ijk", "lmn"} {
+		// Aunque descarte lo que sobra, la escritura nunca falla: el comando no
+		// debe recibir un EPIPE ni bloquearse por superar el límite.
+		if n, err := buf.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if got := buf.buf.String(); got != "abcdefgh" || !buf.truncated {
+		t.Errorf("contenido = %q (truncated = %v), se esperaba \"abcdefgh\" truncado", got, buf.truncated)
+	}
+
+	exact := &cappedBuffer{limit: 3}
+	exact.Write([]byte("abc"))
+	if exact.truncated {
+		t.Error("una salida del tamaño exacto del límite se marcó como truncada")
+	}
+}
+
+func TestRunCommandCapsOutputWhileRunning(t *testing.T) {
+	result, err := runCommand("main", "dump", "head -c 1000000 /dev/zero; echo fallo >&2", 10, "", net.ParseIP("192.0.2.10"), nil, 1024)
+	if err != nil {
+		t.Fatalf("runCommand: %v", err)
+	}
+	if len(result.Stdout) != 1024 || !result.Truncated {
+		t.Errorf("stdout capturado = %d bytes (truncated = %v), se esperaban 1024 truncados", len(result.Stdout), result.Truncated)
+	}
+	if string(result.Stderr) != "fallo\n" {
+		t.Errorf("stderr = %q, se esperaba el mensaje completo", result.Stderr)
+	}
+}
@@ -0,0 +1,481 @@
+package executor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/audit"
+	"github.com/your-org/ghostknock/internal/metrics"
+)
+
+const (
+	journalVersion   = 1
+	journalFilePerms = 0600
+	journalDirPerms  = 0700
+	// revertRetryBase y revertRetryMax acotan la espera entre reintentos de una
+	// reversión fallida, que se duplica en cada intento.
+	revertRetryBase = 30 * time.Second
+	revertRetryMax  = 10 * time.Minute
+)
+
+var (
+	// ErrRevertNotFound indica que la reversión ya no está pendiente.
+	ErrRevertNotFound = errors.New("no existe ninguna reversión pendiente con ese ID")
+	// ErrRevertRunning indica que el comando de la reversión ya se está ejecutando.
+	ErrRevertRunning = errors.New("la reversión ya se está ejecutando")
+)
+
+// PendingRevert describe una reversión programada que aún no se ha ejecutado.
+// Guarda una copia del comando de reversión en el momento de programarla, de
+// modo que una recarga de la configuración no altera lo que se va a deshacer.
+type PendingRevert struct {
+	ID             string            `json:"id"`
+	ActionID       string            `json:"action_id"`
+	User           string            `json:"user"`
+	SourceIP       string            `json:"source_ip"`
+	Params         map[string]string `json:"params,omitempty"`
+	RevertCommand  string            `json:"revert_command"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+	RunAsUser      string            `json:"run_as_user,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	DueAt          time.Time         `json:"due_at"`
+	// Attempts cuenta las ejecuciones fallidas de la reversión.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// journalFile es el formato en disco del diario de reversiones.
+type journalFile struct {
+	Version int             `json:"version"`
+	Reverts []PendingRevert `json:"reverts"`
+}
+
+type scheduledRevert struct {
+	PendingRevert
+	timer *time.Timer
+	// running evita que la misma reversión se ejecute dos veces a la vez
+	// (temporizador y RunNow).
+	running bool
+}
+
+// Scheduler mantiene las reversiones pendientes y las persiste en un diario en
+// disco, para que el acceso "temporal" siga siéndolo aunque el demonio se
+// reinicie o se caiga antes de que venza el retardo.
+type Scheduler struct {
+	mu          sync.Mutex
+	journalPath string
+	pending     map[string]*scheduledRevert
+}
+
+// NewScheduler crea un planificador que persiste sus reversiones en journalPath.
+// Si journalPath está vacío, las reversiones solo se mantienen en memoria.
+func NewScheduler(journalPath string) *Scheduler {
+	return &Scheduler{
+		journalPath: journalPath,
+		pending:     make(map[string]*scheduledRevert),
+	}
+}
+
+// Restore carga el diario y reanuda las reversiones que quedaron pendientes:
+// las vencidas se ejecutan de inmediato y el resto se vuelven a programar.
+func (s *Scheduler) Restore() error {
+	if s.journalPath == "" {
+		return nil
+	}
+
+	info, err := os.Stat(s.journalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el diario de reversiones '%s': %w", s.journalPath, err)
+	}
+	// El diario contiene comandos que se ejecutan como root: solo se confía en él
+	// si nadie más ha podido escribirlo.
+	if err := checkJournalOwner(info); err != nil {
+		untrustedPath := s.setJournalAside(".untrusted")
+		return fmt.Errorf("el diario de reversiones '%s' no es de confianza (movido a '%s'): %w", s.journalPath, untrustedPath, err)
+	}
+
+	data, err := os.ReadFile(s.journalPath)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el diario de reversiones '%s': %w", s.journalPath, err)
+	}
+
+	var journal journalFile
+	if err := json.Unmarshal(data, &journal); err != nil {
+		corruptPath := s.setJournalAside(".corrupt")
+		return fmt.Errorf("el diario de reversiones '%s' está corrupto (movido a '%s'): %w", s.journalPath, corruptPath, err)
+	}
+	if journal.Version != journalVersion {
+		unsupportedPath := s.setJournalAside(".unsupported")
+		return fmt.Errorf("el diario de reversiones '%s' tiene una versión no soportada: %d (movido a '%s')", s.journalPath, journal.Version, unsupportedPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	overdue, rescheduled := 0, 0
+	for _, rev := range journal.Reverts {
+		if _, exists := s.pending[rev.ID]; exists {
+			continue
+		}
+		entry := &scheduledRevert{PendingRevert: rev}
+		s.pending[rev.ID] = entry
+
+		delay := time.Until(rev.DueAt)
+		if delay <= 0 {
+			delay = 0
+			overdue++
+		} else {
+			rescheduled++
+		}
+		s.startTimerLocked(entry, delay)
+	}
+
+	metrics.PendingReverts.Set(float64(len(s.pending)))
+	slog.Info("Diario de reversiones restaurado",
+		"path", s.journalPath,
+		"overdue", overdue,
+		"rescheduled", rescheduled,
+	)
+	return nil
+}
+
+// setJournalAside aparta el diario con el sufijo dado, para que la siguiente
+// escritura no destruya las reversiones que no se han podido restaurar, y
+// devuelve la nueva ruta.
+func (s *Scheduler) setJournalAside(suffix string) string {
+	asidePath := s.journalPath + suffix
+	if err := os.Rename(s.journalPath, asidePath); err != nil {
+		slog.Error("No se pudo apartar el diario de reversiones", "path", s.journalPath, "error", err)
+	}
+	return asidePath
+}
+
+// checkJournalOwner exige que el diario pertenezca a root (o al usuario del
+// demonio) y que no sea escribible por el grupo ni por otros.
+func checkJournalOwner(info os.FileInfo) error {
+	if perm := info.Mode().Perm(); perm&0022 != 0 {
+		return fmt.Errorf("tiene permisos %#o, escribibles por el grupo u otros", perm)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("no se pudo determinar su propietario")
+	}
+	if stat.Uid != 0 && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("pertenece al UID %d en lugar de a root", stat.Uid)
+	}
+	return nil
+}
+
+// Schedule programa la reversión de una acción ya ejecutada y la registra en el diario.
+func (s *Scheduler) Schedule(req Request) {
+	delay := time.Duration(req.Action.RevertDelaySeconds) * time.Second
+	now := time.Now()
+	rev := PendingRevert{
+		ID:             newRevertID(),
+		ActionID:       req.ActionID,
+		User:           req.User,
+		SourceIP:       req.SourceIP.String(),
+		Params:         req.Params,
+		RevertCommand:  req.Action.RevertCommand,
+		TimeoutSeconds: req.Action.TimeoutSeconds,
+		RunAsUser:      req.Action.RunAsUser,
+		CreatedAt:      now,
+		DueAt:          now.Add(delay),
+	}
+
+	slog.Info(
+		"Programando reversión de acción",
+		"revert_id", rev.ID,
+		"action_id", rev.ActionID,
+		"source_ip", rev.SourceIP,
+		"delay", delay.String(),
+	)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := &scheduledRevert{PendingRevert: rev}
+	s.pending[rev.ID] = entry
+	s.startTimerLocked(entry, delay)
+	s.saveLocked()
+
+	audit.Record(audit.Event{
+		Event:    audit.EventRevertScheduled,
+		User:     rev.User,
+		ActionID: rev.ActionID,
+		SourceIP: rev.SourceIP,
+		RevertID: rev.ID,
+		DueAt:    audit.Time(rev.DueAt),
+	})
+}
+
+// List devuelve una copia de todas las reversiones pendientes, ordenadas por vencimiento.
+func (s *Scheduler) List() []PendingRevert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]PendingRevert, 0, len(s.pending))
+	for _, entry := range s.pending {
+		list = append(list, entry.PendingRevert)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DueAt.Before(list[j].DueAt) })
+	return list
+}
+
+// Cancel descarta una reversión pendiente sin ejecutarla. Devuelve false si ya no existe.
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.pending[id]
+	if !ok {
+		return false
+	}
+	entry.timer.Stop()
+	delete(s.pending, id)
+	s.saveLocked()
+	slog.Warn("Reversión cancelada sin ejecutarse", "revert_id", id, "action_id", entry.ActionID, "source_ip", entry.SourceIP)
+	audit.Record(audit.Event{
+		Event:    audit.EventRevertCancelled,
+		User:     entry.User,
+		ActionID: entry.ActionID,
+		SourceIP: entry.SourceIP,
+		RevertID: id,
+	})
+	return true
+}
+
+// Find devuelve las reversiones pendientes de la acción indicada que fueron
+// programadas por el mismo usuario desde la misma IP de origen.
+func (s *Scheduler) Find(user, actionID, sourceIP string) []PendingRevert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []PendingRevert
+	for _, entry := range s.pending {
+		if entry.User == user && entry.ActionID == actionID && entry.SourceIP == sourceIP {
+			matches = append(matches, entry.PendingRevert)
+		}
+	}
+	return matches
+}
+
+// RunNow lanza de inmediato, en segundo plano, una reversión pendiente. Devuelve
+// ErrRevertNotFound si ya no existe y ErrRevertRunning si su comando ya se está
+// ejecutando.
+func (s *Scheduler) RunNow(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.pending[id]
+	if !ok {
+		return ErrRevertNotFound
+	}
+	if entry.running {
+		return ErrRevertRunning
+	}
+	entry.timer.Stop()
+	entry.running = true
+	go s.run(id, entry.PendingRevert)
+	return nil
+}
+
+// Reschedule cambia el vencimiento de una reversión pendiente. Devuelve false si ya no existe.
+func (s *Scheduler) Reschedule(id string, dueAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.pending[id]
+	if !ok {
+		return false
+	}
+	entry.timer.Stop()
+	entry.DueAt = dueAt
+	s.startTimerLocked(entry, time.Until(dueAt))
+	s.saveLocked()
+	audit.Record(audit.Event{
+		Event:    audit.EventRevertRescheduled,
+		User:     entry.User,
+		ActionID: entry.ActionID,
+		SourceIP: entry.SourceIP,
+		RevertID: id,
+		DueAt:    audit.Time(dueAt),
+	})
+	return true
+}
+
+func (s *Scheduler) startTimerLocked(entry *scheduledRevert, delay time.Duration) {
+	id := entry.ID
+	entry.timer = time.AfterFunc(delay, func() { s.fire(id) })
+}
+
+// fire ejecuta la reversión y solo la retira del diario cuando el comando
+// termina con éxito. Mientras se ejecuta sigue en el diario, de modo que si el
+// demonio se cae a mitad, se volverá a ejecutar al arrancar. Si el comando
+// falla, excede su timeout o no llega a lanzarse, se reintenta con una espera
+// creciente.
+func (s *Scheduler) fire(id string) {
+	s.mu.Lock()
+	entry, ok := s.pending[id]
+	if !ok || entry.running {
+		s.mu.Unlock()
+		return
+	}
+	entry.running = true
+	rev := entry.PendingRevert
+	s.mu.Unlock()
+
+	s.run(id, rev)
+}
+
+// run ejecuta una reversión ya marcada como en curso y actualiza el diario
+// según el resultado.
+func (s *Scheduler) run(id string, rev PendingRevert) {
+	result, err := runRevert(rev)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.pending[id]
+	if !ok {
+		// Cancelada mientras se ejecutaba.
+		return
+	}
+	entry.running = false
+	if err == nil {
+		entry.timer.Stop()
+		delete(s.pending, id)
+		s.saveLocked()
+		return
+	}
+
+	entry.Attempts++
+	delay := revertRetryDelay(entry.Attempts)
+	entry.DueAt = time.Now().Add(delay)
+	entry.timer.Stop()
+	s.startTimerLocked(entry, delay)
+	s.saveLocked()
+	attrs := []any{
+		"revert_id", id,
+		"action_id", entry.ActionID,
+		"source_ip", entry.SourceIP,
+		"attempts", entry.Attempts,
+		"retry_in", delay.String(),
+	}
+	// Un comando que ni siquiera llegó a lanzarse (usuario de 'run_as_user'
+	// inexistente, plantilla rota...) no se descarta: la reversión sigue en el
+	// diario por si el problema se corrige, pero exige intervención.
+	if result.Command == "" {
+		slog.Error("La reversión no pudo lanzarse y se reintentará", attrs...)
+		return
+	}
+	slog.Warn("La reversión se reintentará", attrs...)
+}
+
+// revertRetryDelay devuelve la espera antes del siguiente intento de una
+// reversión que ha fallado attempts veces.
+func revertRetryDelay(attempts int) time.Duration {
+	delay := revertRetryBase
+	for i := 1; i < attempts && delay < revertRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, revertRetryMax)
+}
+
+// runRevert ejecuta el comando de reversión guardado.
+func runRevert(rev PendingRevert) (Result, error) {
+	sourceIP := net.ParseIP(rev.SourceIP)
+	slog.Info("Ejecutando reversión", "revert_id", rev.ID, "action_id", rev.ActionID, "source_ip", rev.SourceIP)
+	// La reversión también recibe los parámetros (ej. para cerrar el puerto a una IP específica enviada como param).
+	result, err := runCommand("revert", rev.ActionID, rev.RevertCommand, rev.TimeoutSeconds, rev.RunAsUser, sourceIP, rev.Params, logOutputLimit)
+	auditCommand(audit.Event{
+		User:        rev.User,
+		ActionID:    rev.ActionID,
+		SourceIP:    rev.SourceIP,
+		CommandType: "revert",
+		RunAsUser:   rev.RunAsUser,
+		RevertID:    rev.ID,
+	}, result, err)
+	if err != nil {
+		slog.Error(
+			"Falló la ejecución del comando de reversión",
+			"revert_id", rev.ID,
+			"action_id", rev.ActionID,
+			"source_ip", rev.SourceIP,
+			"error", err,
+		)
+	}
+	return result, err
+}
+
+// saveLocked escribe el diario completo de forma atómica (archivo temporal + rename)
+// y actualiza la métrica de reversiones pendientes.
+// Un fallo de escritura se registra pero no impide que la reversión se ejecute
+// mientras el demonio siga vivo.
+func (s *Scheduler) saveLocked() {
+	metrics.PendingReverts.Set(float64(len(s.pending)))
+	if s.journalPath == "" {
+		return
+	}
+
+	journal := journalFile{Version: journalVersion, Reverts: make([]PendingRevert, 0, len(s.pending))}
+	for _, entry := range s.pending {
+		journal.Reverts = append(journal.Reverts, entry.PendingRevert)
+	}
+
+	if err := writeJournal(s.journalPath, journal); err != nil {
+		slog.Error("No se pudo persistir el diario de reversiones", "path", s.journalPath, "error", err)
+	}
+}
+
+func writeJournal(path string, journal journalFile) error {
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, journalDirPerms); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(journalFilePerms); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func newRevertID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,217 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/config"
+)
+
+// readJournal devuelve las reversiones guardadas en el diario.
+func readJournal(t *testing.T, path string) []PendingRevert {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("no se pudo leer el diario: %v", err)
+	}
+	var journal journalFile
+	if err := json.Unmarshal(data, &journal); err != nil {
+		t.Fatalf("diario inválido: %v", err)
+	}
+	return journal.Reverts
+}
+
+// waitForFile espera a que el comando de reversión cree el archivo marcador.
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("la reversión no llegó a ejecutarse (%s no existe)", path)
+}
+
+func TestSchedulerJournalsUntilFired(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "reverts.json")
+	marker := filepath.Join(dir, "reverted")
+
+	s := NewScheduler(journalPath)
+	s.Schedule(Request{
+		ActionID: "open-ssh",
+		User:     "alice",
+		SourceIP: net.ParseIP("192.0.2.10"),
+		Action:   config.Action{RevertCommand: "touch " + marker, RevertDelaySeconds: 1},
+	})
+
+	reverts := readJournal(t, journalPath)
+	if len(reverts) != 1 || reverts[0].ActionID != "open-ssh" || reverts[0].SourceIP != "192.0.2.10" {
+		t.Fatalf("diario tras programar = %+v, se esperaba la reversión de open-ssh", reverts)
+	}
+	if info, err := os.Stat(journalPath); err != nil || info.Mode().Perm() != journalFilePerms {
+		t.Errorf("permisos del diario = %v, %v; se esperaba %o", info.Mode().Perm(), err, journalFilePerms)
+	}
+
+	// La entrada sigue en el diario mientras el comando se ejecuta y sale al terminar con éxito.
+	waitForFile(t, marker)
+	waitForJournal(t, journalPath, func(reverts []PendingRevert) bool { return len(reverts) == 0 })
+}
+
+// waitForJournal espera a que el diario cumpla la condición dada.
+func waitForJournal(t *testing.T, path string, done func([]PendingRevert) bool) []PendingRevert {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reverts := readJournal(t, path)
+		if done(reverts) {
+			return reverts
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("el diario no llegó al estado esperado: %+v", reverts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerRetriesFailedRevert(t *testing.T) {
+	// Tanto un comando que falla como uno que ni siquiera llega a lanzarse
+	// (usuario inexistente) dejan la reversión en el diario, con el intento
+	// contado y el siguiente programado tras la espera base.
+	for _, action := range []config.Action{
+		{RevertCommand: "exit 1"},
+		{RevertCommand: "true", RunAsUser: "ghostknock-no-such-user"},
+	} {
+		journalPath := filepath.Join(t.TempDir(), "reverts.json")
+		s := NewScheduler(journalPath)
+		s.Schedule(Request{
+			ActionID: "open-ssh",
+			User:     "alice",
+			SourceIP: net.ParseIP("192.0.2.10"),
+			Action:   action,
+		})
+
+		reverts := waitForJournal(t, journalPath, func(reverts []PendingRevert) bool {
+			return len(reverts) == 1 && reverts[0].Attempts == 1
+		})
+		if wait := time.Until(reverts[0].DueAt); wait < revertRetryBase-time.Second || wait > revertRetryBase {
+			t.Errorf("%+v: siguiente intento en %v, se esperaba en %v", action, wait, revertRetryBase)
+		}
+		if !s.Cancel(reverts[0].ID) {
+			t.Errorf("%+v: la reversión fallida ya no está pendiente", action)
+		}
+	}
+
+	for attempts, want := range map[int]time.Duration{1: revertRetryBase, 2: 2 * revertRetryBase, 3: 4 * revertRetryBase, 100: revertRetryMax} {
+		if got := revertRetryDelay(attempts); got != want {
+			t.Errorf("revertRetryDelay(%d) = %v, se esperaba %v", attempts, got, want)
+		}
+	}
+}
+
+func TestSchedulerRunNow(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "reverts.json")
+	marker := filepath.Join(t.TempDir(), "reverted")
+	s := NewScheduler(journalPath)
+	s.Schedule(Request{
+		ActionID: "open-ssh",
+		User:     "alice",
+		SourceIP: net.ParseIP("192.0.2.10"),
+		Action:   config.Action{RevertCommand: "sleep 1; touch " + marker, RevertDelaySeconds: 3600},
+	})
+	id := s.List()[0].ID
+
+	// RunNow no espera al comando, y mientras se ejecuta no lo relanza.
+	started := time.Now()
+	if err := s.RunNow(id); err != nil {
+		t.Fatalf("RunNow: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("RunNow tardó %v: esperó al comando", elapsed)
+	}
+	if err := s.RunNow(id); !errors.Is(err, ErrRevertRunning) {
+		t.Errorf("RunNow durante la ejecución = %v, se esperaba ErrRevertRunning", err)
+	}
+
+	waitForFile(t, marker)
+	waitForJournal(t, journalPath, func(reverts []PendingRevert) bool { return len(reverts) == 0 })
+	if err := s.RunNow(id); !errors.Is(err, ErrRevertNotFound) {
+		t.Errorf("RunNow tras terminar = %v, se esperaba ErrRevertNotFound", err)
+	}
+}
+
+func TestSchedulerRestore(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "reverts.json")
+	overdueMarker := filepath.Join(dir, "overdue")
+	now := time.Now()
+
+	err := writeJournal(journalPath, journalFile{Version: journalVersion, Reverts: []PendingRevert{
+		{ID: "overdue", ActionID: "open-ssh", RevertCommand: "touch " + overdueMarker, CreatedAt: now.Add(-time.Hour), DueAt: now.Add(-time.Minute)},
+		{ID: "future", ActionID: "open-ssh", RevertCommand: "true", CreatedAt: now, DueAt: now.Add(time.Hour)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(journalPath)
+	if err := s.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	// La reversión vencida durante la parada se ejecuta al arrancar.
+	waitForFile(t, overdueMarker)
+
+	s.mu.Lock()
+	_, futurePending := s.pending["future"]
+	s.mu.Unlock()
+	if !futurePending {
+		t.Error("la reversión futura no se volvió a programar")
+	}
+}
+
+// Un diario que no se puede restaurar se aparta para que la siguiente
+// escritura no destruya las reversiones que contiene.
+func TestSchedulerRestoreSetsAsideBadJournal(t *testing.T) {
+	valid, err := json.Marshal(journalFile{Version: journalVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	future, err := json.Marshal(journalFile{Version: journalVersion + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		data   []byte
+		perm   os.FileMode
+		suffix string
+	}{
+		"corrupto":            {[]byte("{no es json"), 0600, ".corrupt"},
+		"versión desconocida": {future, 0600, ".unsupported"},
+		// Cualquiera podría haber añadido comandos que el demonio ejecutaría como root.
+		"escribible por otros": {valid, 0666, ".untrusted"},
+	}
+	for name, tt := range tests {
+		journalPath := filepath.Join(t.TempDir(), "reverts.json")
+		if err := os.WriteFile(journalPath, tt.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(journalPath, tt.perm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := NewScheduler(journalPath).Restore(); err == nil {
+			t.Errorf("%s: Restore() aceptó el diario", name)
+		}
+		if _, err := os.Stat(journalPath + tt.suffix); err != nil {
+			t.Errorf("%s: el diario no se apartó a %s: %v", name, tt.suffix, err)
+		}
+	}
+}
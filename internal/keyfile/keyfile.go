@@ -0,0 +1,190 @@
+// El paquete keyfile lee y escribe los archivos de clave privada ed25519 del
+// cliente, tanto en bruto (64 bytes, el formato histórico) como cifrados con
+// una frase de paso. También lee claves ed25519 de OpenSSH (id_ed25519).
+package keyfile
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ssh"
+)
+
+// Formato de un archivo cifrado: un bloque PEM de tipo EncryptedPEMType cuyo
+// contenido es
+//
+//	+---------+-----+-----------+-------------+---------+----------+-------------+--------------------------+
+//	| Versión | KDF | Iteración | Memoria KiB | Hilos   | Sal      | Nonce       | Clave cifrada + etiqueta |
+//	| 1 B     | 1 B | 4 B (BE)  | 4 B (BE)    | 1 B     | 16 B     | 24 B        | 64 B + 16 B              |
+//	+---------+-----+-----------+-------------+---------+----------+-------------+--------------------------+
+//
+// La clave simétrica se deriva de la frase de paso con Argon2id y los parámetros
+// de la cabecera, y la clave privada se cifra con XChaCha20-Poly1305. Toda la
+// cabecera es dato adicional autenticado, así que no puede alterarse (por
+// ejemplo, para rebajar el coste de Argon2id) sin que el descifrado falle.
+const (
+	// EncryptedPEMType es el tipo del bloque PEM de una clave cifrada.
+	EncryptedPEMType = "GHOSTKNOCK ENCRYPTED PRIVATE KEY"
+	// OpenSSHPEMType es el tipo del bloque PEM de una clave privada de OpenSSH.
+	OpenSSHPEMType = "OPENSSH PRIVATE KEY"
+
+	formatVersion  = 1
+	kdfArgon2id    = 1
+	saltSize       = 16
+	saltOffset     = 1 + 1 + 4 + 4 + 1
+	headerSize     = saltOffset + saltSize + chacha20poly1305.NonceSizeX
+	argon2Time     = 3
+	argon2MemoryKB = 64 * 1024
+	argon2Threads  = 4
+	// Límites al leer un archivo, para que uno manipulado no agote la memoria.
+	maxArgon2MemoryKB = 1024 * 1024
+	maxArgon2Time     = 16
+)
+
+// ErrWrongPassphrase indica que la frase de paso no descifra la clave (o que el archivo fue alterado).
+var ErrWrongPassphrase = errors.New("frase de paso incorrecta o archivo de clave dañado")
+
+// PassphraseFunc obtiene la frase de paso cuando la clave está cifrada.
+type PassphraseFunc func() ([]byte, error)
+
+// Encrypt cifra la clave privada con la frase de paso y devuelve el archivo PEM.
+func Encrypt(privateKey ed25519.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("la frase de paso no puede estar vacía")
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, formatVersion, kdfArgon2id)
+	header = binary.BigEndian.AppendUint32(header, argon2Time)
+	header = binary.BigEndian.AppendUint32(header, argon2MemoryKB)
+	header = append(header, argon2Threads)
+	saltAndNonce := make([]byte, saltSize+chacha20poly1305.NonceSizeX)
+	rand.Read(saltAndNonce)
+	header = append(header, saltAndNonce...)
+
+	salt := header[saltOffset : saltOffset+saltSize]
+	nonce := header[saltOffset+saltSize:]
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, argon2Time, argon2MemoryKB, argon2Threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	body := aead.Seal(header, nonce, privateKey, header)
+	return pem.EncodeToMemory(&pem.Block{Type: EncryptedPEMType, Bytes: body}), nil
+}
+
+// IsEncrypted indica si el contenido es una clave cifrada con Encrypt.
+func IsEncrypted(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == EncryptedPEMType
+}
+
+// Decrypt descifra un archivo producido por Encrypt.
+func Decrypt(data, passphrase []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != EncryptedPEMType {
+		return nil, errors.New("el archivo no es una clave cifrada de GhostKnock")
+	}
+	body := block.Bytes
+	if len(body) < headerSize {
+		return nil, errors.New("la clave cifrada está truncada")
+	}
+	if body[0] != formatVersion {
+		return nil, fmt.Errorf("versión de clave cifrada no soportada: %d", body[0])
+	}
+	if body[1] != kdfArgon2id {
+		return nil, fmt.Errorf("función de derivación de clave no soportada: %d", body[1])
+	}
+
+	timeCost := binary.BigEndian.Uint32(body[2:6])
+	memoryKB := binary.BigEndian.Uint32(body[6:10])
+	threads := body[10]
+	if timeCost == 0 || timeCost > maxArgon2Time || memoryKB == 0 || memoryKB > maxArgon2MemoryKB || threads == 0 {
+		return nil, errors.New("parámetros de Argon2id fuera de rango en la clave cifrada")
+	}
+
+	header := body[:headerSize]
+	salt := header[saltOffset : saltOffset+saltSize]
+	nonce := header[saltOffset+saltSize:]
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, timeCost, memoryKB, threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, body[headerSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(plaintext) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("la clave descifrada tiene un tamaño incorrecto: %d bytes", len(plaintext))
+	}
+	return ed25519.PrivateKey(plaintext), nil
+}
+
+// IsOpenSSH indica si el contenido es una clave privada en formato de OpenSSH.
+func IsOpenSSH(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == OpenSSHPEMType
+}
+
+// ParseOpenSSH lee una clave privada ed25519 de OpenSSH. La frase de paso solo
+// se solicita si la clave está cifrada (ssh-keygen -N).
+func ParseOpenSSH(data []byte, passphrase PassphraseFunc) (ed25519.PrivateKey, error) {
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		pass, passErr := passphrase()
+		if passErr != nil {
+			return nil, fmt.Errorf("no se pudo obtener la frase de paso: %w", passErr)
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, pass)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, ErrWrongPassphrase
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("clave de OpenSSH inválida: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *ed25519.PrivateKey:
+		return *k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("tipo de clave de OpenSSH no soportado (%T): GhostKnock solo admite ed25519", key)
+	}
+}
+
+// Load lee una clave privada ed25519 en bruto, cifrada o de OpenSSH. La frase
+// de paso solo se solicita si el archivo está cifrado.
+func Load(path string, passphrase PassphraseFunc) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsOpenSSH(data) {
+		return ParseOpenSSH(data, passphrase)
+	}
+	if IsEncrypted(data) {
+		pass, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo obtener la frase de paso: %w", err)
+		}
+		return Decrypt(data, pass)
+	}
+
+	if len(data) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("tamaño incorrecto: se esperaban %d bytes, tiene %d", ed25519.PrivateKeySize, len(data))
+	}
+	return ed25519.PrivateKey(data), nil
+}
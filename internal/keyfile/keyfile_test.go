@@ -0,0 +1,165 @@
+package keyfile
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := Encrypt(privateKey, []byte("correcta"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(encrypted) {
+		t.Fatal("IsEncrypted() no reconoce el resultado de Encrypt")
+	}
+
+	got, err := Decrypt(encrypted, []byte("correcta"))
+	if err != nil {
+		t.Fatalf("Decrypt con la frase correcta: %v", err)
+	}
+	if !got.Equal(privateKey) {
+		t.Fatal("Decrypt() devolvió una clave distinta de la cifrada")
+	}
+
+	for _, wrong := range []string{"incorrecta", "correct", ""} {
+		if _, err := Decrypt(encrypted, []byte(wrong)); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("Decrypt con la frase %q: error = %v, se esperaba %v", wrong, err, ErrWrongPassphrase)
+		}
+	}
+}
+
+func TestDecryptTamperedFile(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := Encrypt(privateKey, []byte("correcta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rewrite altera el contenido del bloque PEM, manteniendo su tipo.
+	rewrite := func(edit func(body []byte)) []byte {
+		block, _ := pem.Decode(encrypted)
+		body := append([]byte(nil), block.Bytes...)
+		edit(body)
+		return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: body})
+	}
+
+	// La cabecera es dato autenticado: rebajar el coste de Argon2id para
+	// acelerar un ataque de diccionario invalida el archivo.
+	cheaper := rewrite(func(body []byte) { binary.BigEndian.PutUint32(body[2:6], 1) })
+	if _, err := Decrypt(cheaper, []byte("correcta")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("coste rebajado: error = %v, se esperaba %v", err, ErrWrongPassphrase)
+	}
+	flipped := rewrite(func(body []byte) { body[len(body)-1] ^= 0xff })
+	if _, err := Decrypt(flipped, []byte("correcta")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("clave cifrada alterada: error = %v, se esperaba %v", err, ErrWrongPassphrase)
+	}
+
+	// Unos parámetros desorbitados se rechazan antes de derivar la clave.
+	hungry := rewrite(func(body []byte) { binary.BigEndian.PutUint32(body[6:10], maxArgon2MemoryKB+1) })
+	if _, err := Decrypt(hungry, []byte("correcta")); err == nil {
+		t.Error("Decrypt() aceptó una memoria de Argon2id fuera de rango")
+	}
+	future := rewrite(func(body []byte) { body[0] = formatVersion + 1 })
+	if _, err := Decrypt(future, []byte("correcta")); err == nil {
+		t.Error("Decrypt() aceptó una versión de formato desconocida")
+	}
+}
+
+func TestEncryptRejectsEmptyPassphrase(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Encrypt(privateKey, nil); err == nil {
+		t.Fatal("Encrypt() aceptó una frase de paso vacía")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := Encrypt(privateKey, []byte("correcta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	openSSH := func(passphrase string) []byte {
+		var block *pem.Block
+		var err error
+		if passphrase == "" {
+			block, err = ssh.MarshalPrivateKey(privateKey, "")
+		} else {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte(passphrase))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(block)
+	}
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	passphrase := func(value string) PassphraseFunc {
+		return func() ([]byte, error) { return []byte(value), nil }
+	}
+	noPrompt := func() ([]byte, error) {
+		t.Error("se pidió la frase de paso para una clave sin cifrar")
+		return nil, errors.New("no se esperaba la frase de paso")
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		passphrase PassphraseFunc
+		wantErr    error
+	}{
+		{"en bruto", write("raw.key", privateKey), noPrompt, nil},
+		{"cifrada", write("encrypted.key", encrypted), passphrase("correcta"), nil},
+		{"cifrada con frase incorrecta", write("encrypted-wrong.key", encrypted), passphrase("incorrecta"), ErrWrongPassphrase},
+		{"OpenSSH", write("id_ed25519", openSSH("")), noPrompt, nil},
+		{"OpenSSH cifrada", write("id_ed25519_enc", openSSH("correcta")), passphrase("correcta"), nil},
+		{"OpenSSH cifrada con frase incorrecta", write("id_ed25519_wrong", openSSH("correcta")), passphrase("incorrecta"), ErrWrongPassphrase},
+		{"tamaño incorrecto", write("short.key", privateKey[:32]), noPrompt, errAny},
+		{"inexistente", filepath.Join(dir, "missing.key"), noPrompt, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.path, tt.passphrase)
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatal("Load() no devolvió error")
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("Load() error = %v, se esperaba %v", err, tt.wantErr)
+			case err == nil && !got.Equal(privateKey):
+				t.Fatal("Load() devolvió una clave distinta")
+			}
+		})
+	}
+}
+
+// errAny marca en las tablas los casos en los que basta con que haya error.
+var errAny = errors.New("cualquier error")
@@ -0,0 +1,64 @@
+package keyfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnv es la variable de entorno de la que se lee la frase de paso
+// en entornos no interactivos.
+const PassphraseEnv = "GHOSTKNOCK_PASSPHRASE"
+
+// ReadPassphrase obtiene la frase de paso, por orden de preferencia: de la
+// primera línea del descriptor fd (si es >= 0), de la variable PassphraseEnv o
+// preguntándola en la terminal sin eco.
+func ReadPassphrase(fd int, prompt string) ([]byte, error) {
+	if fd >= 0 {
+		return readPassphraseFD(fd)
+	}
+	if pass, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(pass), nil
+	}
+	return PromptPassphrase(prompt)
+}
+
+// PromptPassphrase pregunta la frase de paso en la terminal sin eco. Usa
+// /dev/tty cuando existe, de modo que funciona aunque stdin esté redirigido
+// (por ejemplo, como ProxyCommand de OpenSSH).
+func PromptPassphrase(prompt string) ([]byte, error) {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		fmt.Fprint(tty, prompt)
+		pass, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		return pass, err
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return nil, fmt.Errorf("no hay una terminal para pedir la frase de paso; use %s o un descriptor de archivo", PassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(stdin)
+	fmt.Fprintln(os.Stderr)
+	return pass, err
+}
+
+func readPassphraseFD(fd int) ([]byte, error) {
+	file := os.NewFile(uintptr(fd), "passphrase")
+	if file == nil {
+		return nil, fmt.Errorf("descriptor de archivo inválido: %d", fd)
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, errors.New("no se pudo leer la frase de paso del descriptor de archivo")
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -10,14 +10,22 @@ import (
 	"time"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/your-org/ghostknock/internal/config" // <<-- NUEVA IMPORTACIÓN
 )
 
+// snapshotLen es el número de bytes capturados por paquete. Incluye las
+// cabeceras de enlace, IP y UDP, así que debe dejar sitio de sobra para un
+// knock de protocol.MaxMessageSize: con menos, los knocks grandes llegarían
+// recortados y fallarían como firma inválida.
+const snapshotLen = 65535
+
 // PacketInfo contiene el payload de un paquete y metadatos relevantes.
 type PacketInfo struct {
-	Payload  []byte
-	SourceIP net.IP
+	Payload    []byte
+	SourceIP   net.IP
+	SourcePort int // Puerto UDP de origen, usado para enviar acuses de recibo.
 }
 
 // Start ahora acepta una struct config.Listener para mayor flexibilidad.
@@ -27,7 +35,7 @@ func Start(ctx context.Context, listenerCfg config.Listener, packetsCh chan<- Pa
 	slog.Info("Iniciando escucha pasiva", "interface", listenerCfg.Interface, "udp_port", listenerCfg.Port)
 
 	const pcapTimeout = 300 * time.Millisecond
-	handle, err := pcap.OpenLive(listenerCfg.Interface, 1024, true, pcapTimeout)
+	handle, err := pcap.OpenLive(listenerCfg.Interface, snapshotLen, true, pcapTimeout)
 	if err != nil {
 		slog.Error("Error al abrir la interfaz de captura", "interface", listenerCfg.Interface, "error", err)
 		os.Exit(1)
@@ -60,6 +68,10 @@ func Start(ctx context.Context, listenerCfg config.Listener, packetsCh chan<- Pa
 			if packet == nil {
 				continue
 			}
+			if packet.Metadata().Truncated {
+				slog.Debug("Paquete recortado en la captura, descartado", "captured_bytes", packet.Metadata().CaptureLength, "length", packet.Metadata().Length)
+				continue
+			}
 
 			var srcIP net.IP
 			if netLayer := packet.NetworkLayer(); netLayer != nil {
@@ -71,6 +83,9 @@ func Start(ctx context.Context, listenerCfg config.Listener, packetsCh chan<- Pa
 					Payload:  appLayer.Payload(),
 					SourceIP: srcIP,
 				}
+				if udp, ok := packet.TransportLayer().(*layers.UDP); ok {
+					packetInfo.SourcePort = int(udp.SrcPort)
+				}
 				select {
 				case packetsCh <- packetInfo:
 				case <-ctx.Done():
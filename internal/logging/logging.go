@@ -0,0 +1,64 @@
+// El paquete logging construye el handler de slog del log operativo de
+// ghostknockd según el destino configurado: un archivo, stderr (journald bajo
+// systemd) o un colector syslog RFC 5424.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/your-org/ghostknock/internal/config"
+)
+
+const logFilePerms = 0644
+
+// New crea el handler para la configuración dada. El io.Closer devuelto libera
+// el destino (archivo o conexión syslog) y debe cerrarse al terminar.
+func New(cfg config.Logging, level slog.Leveler) (slog.Handler, io.Closer, error) {
+	switch cfg.Output {
+	case "stderr":
+		return newFormatHandler(os.Stderr, cfg.Format, &slog.HandlerOptions{Level: level}), nopCloser{}, nil
+
+	case "syslog":
+		sink, err := dialSyslog(cfg.Syslog)
+		if err != nil {
+			return nil, nil, err
+		}
+		// La cabecera syslog ya lleva la marca de tiempo y la severidad.
+		opts := &slog.HandlerOptions{Level: level, ReplaceAttr: dropTimeAndLevel}
+		return newSyslogHandler(sink, cfg.Format, opts), sink, nil
+
+	default:
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFilePerms)
+		if err != nil {
+			return nil, nil, fmt.Errorf("no se pudo abrir el archivo de log en '%s': %w", cfg.File, err)
+		}
+		return newFormatHandler(file, cfg.Format, &slog.HandlerOptions{Level: level}), file, nil
+	}
+}
+
+// Fallback devuelve un handler de texto sobre stderr, usado cuando el destino
+// configurado no está disponible para no perder los mensajes.
+func Fallback(level slog.Leveler) slog.Handler {
+	return slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+}
+
+func newFormatHandler(w io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func dropTimeAndLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+		return slog.Attr{}
+	}
+	return a
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/config"
+)
+
+// Códigos de facility de RFC 5424 (sección 6.2.1).
+var facilities = map[string]int{
+	"user":     1,
+	"daemon":   3,
+	"auth":     4,
+	"authpriv": 10,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// rfc5424Time es el formato de TIMESTAMP de RFC 5424 (como máximo 6 decimales).
+const rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+
+// syslogSink mantiene la conexión con el colector y serializa los envíos. Un
+// mensaje por datagrama, tanto en el socket local (unixgram) como en UDP.
+type syslogSink struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	pid      string
+	conn     net.Conn
+	buf      bytes.Buffer
+}
+
+func dialSyslog(cfg config.Syslog) (*syslogSink, error) {
+	facility, ok := facilities[cfg.Facility]
+	if !ok {
+		return nil, fmt.Errorf("facility de syslog desconocida: '%s'", cfg.Facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	sink := &syslogSink{
+		network:  cfg.Network,
+		address:  cfg.Address,
+		facility: facility,
+		tag:      cfg.Tag,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	if err := sink.connectLocked(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *syslogSink) connectLocked() error {
+	conn, err := net.Dial(s.network, s.address)
+	if err != nil {
+		return fmt.Errorf("no se pudo conectar con syslog en %s '%s': %w", s.network, s.address, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// sendLocked envía el contenido de buf como un mensaje RFC 5424. Si la
+// conexión se ha perdido (ej. reinicio de rsyslog), reconecta una vez.
+func (s *syslogSink) sendLocked(t time.Time, level slog.Level) error {
+	msg := bytes.TrimRight(s.buf.Bytes(), "\n")
+	priority := s.facility*8 + severity(level)
+
+	var frame bytes.Buffer
+	fmt.Fprintf(&frame, "<%d>1 %s %s %s %s - - ", priority, t.Format(rfc5424Time), s.hostname, s.tag, s.pid)
+	frame.Write(msg)
+
+	if s.conn != nil {
+		if _, err := s.conn.Write(frame.Bytes()); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connectLocked(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(frame.Bytes())
+	return err
+}
+
+// Close cierra la conexión con el colector.
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// severity traduce el nivel de slog a la severidad de RFC 5424.
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// syslogHandler formatea cada registro con un handler de texto o JSON sobre el
+// buffer del sink y lo envía como el MSG de un mensaje syslog.
+type syslogHandler struct {
+	inner slog.Handler
+	sink  *syslogSink
+}
+
+func newSyslogHandler(sink *syslogSink, format string, opts *slog.HandlerOptions) *syslogHandler {
+	return &syslogHandler{inner: newFormatHandler(&sink.buf, format, opts), sink: sink}
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+
+	h.sink.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return h.sink.sendLocked(t, r.Level)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithAttrs(attrs), sink: h.sink}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithGroup(name), sink: h.sink}
+}
@@ -0,0 +1,97 @@
+// El paquete metrics define las métricas Prometheus del demonio y el servidor
+// HTTP opcional que las expone.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ghostknock"
+
+var (
+	// KnocksDropped cuenta los paquetes descartados por motivo, usuario y acción.
+	// Usuario y acción quedan vacíos si el paquete se descartó antes de conocerlos,
+	// y las acciones que no existen en la configuración se cuentan como "unknown".
+	KnocksDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "knocks_dropped_total",
+		Help:      "Knocks descartados, por motivo de descarte.",
+	}, []string{"reason", "user", "action"})
+
+	// KnocksAccepted cuenta los knocks autorizados por usuario y acción.
+	KnocksAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "knocks_accepted_total",
+		Help:      "Knocks válidos y autorizados.",
+	}, []string{"user", "action"})
+
+	// CommandsExecuted cuenta las ejecuciones de comandos por tipo (main/revert),
+	// acción, resultado y código de salida.
+	CommandsExecuted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_executed_total",
+		Help:      "Comandos ejecutados, por resultado y código de salida.",
+	}, []string{"type", "action", "status", "exit_code"})
+
+	// CommandDuration mide la duración de los comandos ejecutados.
+	CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Duración de los comandos ejecutados.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 15, 60, 300, 900},
+	}, []string{"type", "action"})
+
+	// PendingReverts indica cuántas reversiones están programadas y pendientes.
+	PendingReverts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_reverts",
+		Help:      "Reversiones programadas pendientes de ejecutar.",
+	})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		KnocksDropped,
+		KnocksAccepted,
+		CommandsExecuted,
+		CommandDuration,
+		PendingReverts,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Serve expone las métricas en addr bajo la ruta dada hasta que se cancele el contexto.
+func Serve(ctx context.Context, addr, path string) error {
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Endpoint de métricas activo", "listen", addr, "path", path)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
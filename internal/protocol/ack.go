@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FlagAck indica que el sobre es un acuse de recibo del servidor y no un knock.
+const FlagAck uint8 = 1 << 1
+
+// Estados de un acuse de recibo.
+const (
+	// AckSuccess indica que el comando de la acción terminó con código 0.
+	AckSuccess = "success"
+	// AckFailed indica que el comando falló, excedió su timeout o no pudo lanzarse.
+	AckFailed = "failed"
+	// AckCooldown indica que la acción sigue en cooldown y no se ha ejecutado.
+	AckCooldown = "cooldown"
+	// AckAccepted indica que un verbo de control se ha aplicado.
+	AckAccepted = "accepted"
+	// AckRejected indica que un verbo de control no se ha podido aplicar.
+	AckRejected = "rejected"
+	// AckPending indica que la acción espera aprobaciones de otros usuarios.
+	AckPending = "pending"
+)
+
+// Ack es la respuesta firmada que el servidor envía al cliente cuando la acción
+// tiene activado 'acknowledge'. Está ligada al knock por su nonce, de modo que
+// un acuse capturado no puede hacerse pasar por la respuesta a otro knock.
+type Ack struct {
+	RequestNonce string `json:"request_nonce"`
+	Timestamp    int64  `json:"timestamp"`
+	Status       string `json:"status"`
+	ExitCode     *int   `json:"exit_code,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// OutputChunks es el número de fragmentos de salida que acompañan al acuse.
+	OutputChunks int `json:"output_chunks,omitempty"`
+}
+
+// NewAck crea un acuse de recibo para el knock con el nonce indicado.
+func NewAck(requestNonce, status string) *Ack {
+	return &Ack{
+		RequestNonce: requestNonce,
+		Timestamp:    time.Now().UnixNano(),
+		Status:       status,
+	}
+}
+
+// Marshal firma el acuse con la clave del servidor y lo serializa en un sobre.
+func (a *Ack) Marshal(serverKey ed25519.PrivateKey) ([]byte, error) {
+	if a.RequestNonce == "" {
+		return nil, errors.New("el acuse de recibo no contiene el nonce del knock")
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	env, err := NewEnvelope(body, FlagAck, serverKey)
+	if err != nil {
+		return nil, err
+	}
+	return env.Marshal()
+}
+
+// ParseAck verifica un acuse de recibo con la clave pública del servidor y
+// comprueba que responde al knock con el nonce indicado.
+func ParseAck(data []byte, serverKey ed25519.PublicKey, requestNonce string) (*Ack, error) {
+	env, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.Flags&FlagAck == 0 {
+		return nil, errors.New("el paquete no es un acuse de recibo")
+	}
+	if env.KeyID != KeyIDFromPublicKey(serverKey) || !env.Verify(serverKey) {
+		return nil, errors.New("la firma del acuse de recibo no corresponde a la clave del servidor")
+	}
+
+	var ack Ack
+	if err := json.Unmarshal(env.Body, &ack); err != nil {
+		return nil, fmt.Errorf("fallo al deserializar el acuse de recibo: %w", err)
+	}
+	if ack.RequestNonce != requestNonce {
+		return nil, errors.New("el acuse de recibo corresponde a otro knock")
+	}
+	return &ack, nil
+}
@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FlagCertificate indica que el cuerpo del sobre empieza con un certificado
+// firmado por una CA en la que confía el servidor.
+const FlagCertificate uint8 = 1 << 3
+
+// Formato de un certificado:
+//
+//	[JSON de Certificate][firma ed25519 de la CA sobre el JSON (64 B)]
+//
+// Con FlagCertificate, el cuerpo del sobre es
+//
+//	[longitud del certificado (2 B)][certificado][payload, sellado o en claro]
+//
+// La firma del sobre, hecha con la clave del titular, cubre también el
+// certificado, de modo que no puede trasplantarse a otro knock.
+const (
+	// CertificateVersion es la versión del formato de certificado.
+	CertificateVersion = 1
+	// CertificatePEMType es el tipo del bloque PEM de un archivo de certificado.
+	CertificatePEMType = "GHOSTKNOCK CERTIFICATE"
+	certSerialSize     = 8
+)
+
+// Certificate autoriza a la clave PublicKey a solicitar Actions desde SourceIPs
+// durante su periodo de validez, en nombre de Subject.
+type Certificate struct {
+	Version   int      `json:"v"`
+	Serial    string   `json:"serial"`
+	CAKeyID   string   `json:"ca"` // Key ID (hex) de la CA firmante.
+	Subject   string   `json:"sub"`
+	PublicKey []byte   `json:"key"`
+	Actions   []string `json:"actions"`
+	SourceIPs []string `json:"source_ips,omitempty"`
+	NotBefore int64    `json:"nbf"` // Segundos Unix.
+	NotAfter  int64    `json:"exp"` // Segundos Unix.
+
+	raw       []byte
+	signature []byte
+}
+
+// NewCertificate prepara un certificado con un número de serie aleatorio,
+// válido desde notBefore durante lifetime.
+func NewCertificate(subject string, publicKey ed25519.PublicKey, actions, sourceIPs []string, notBefore time.Time, lifetime time.Duration) *Certificate {
+	serial := make([]byte, certSerialSize)
+	rand.Read(serial)
+	return &Certificate{
+		Version:   CertificateVersion,
+		Serial:    hex.EncodeToString(serial),
+		Subject:   subject,
+		PublicKey: publicKey,
+		Actions:   actions,
+		SourceIPs: sourceIPs,
+		NotBefore: notBefore.Unix(),
+		NotAfter:  notBefore.Add(lifetime).Unix(),
+	}
+}
+
+// Sign firma el certificado con la clave de la CA y devuelve su forma binaria.
+func (c *Certificate) Sign(caKey crypto.Signer) ([]byte, error) {
+	caPublicKey, ok := caKey.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("la clave de la CA debe ser ed25519, es %T", caKey.Public())
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	caKeyID := KeyIDFromPublicKey(caPublicKey)
+	c.CAKeyID = hex.EncodeToString(caKeyID[:])
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := caKey.Sign(nil, raw, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("fallo al firmar el certificado: %w", err)
+	}
+	c.raw, c.signature = raw, signature
+	return append(raw, signature...), nil
+}
+
+// ParseCertificate interpreta un certificado binario. No verifica la firma de
+// la CA: el llamante debe hacerlo con Verify.
+func ParseCertificate(data []byte) (*Certificate, error) {
+	if len(data) <= ed25519.SignatureSize {
+		return nil, ErrTruncated
+	}
+	raw := data[:len(data)-ed25519.SignatureSize]
+	var c Certificate
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("fallo al deserializar el certificado: %w", err)
+	}
+	if c.Version != CertificateVersion {
+		return nil, fmt.Errorf("versión de certificado no soportada: %d", c.Version)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c.raw = raw
+	c.signature = data[len(raw):]
+	return &c, nil
+}
+
+func (c *Certificate) validate() error {
+	if c.Subject == "" {
+		return errors.New("el certificado no tiene titular")
+	}
+	if len(c.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("la clave del certificado debe tener %d bytes, tiene %d", ed25519.PublicKeySize, len(c.PublicKey))
+	}
+	if len(c.Actions) == 0 {
+		return errors.New("el certificado no autoriza ninguna acción")
+	}
+	if c.NotAfter <= c.NotBefore {
+		return errors.New("el certificado tiene un periodo de validez vacío")
+	}
+	return nil
+}
+
+// CAKey devuelve el key ID de la CA que firmó el certificado.
+func (c *Certificate) CAKey() ([KeyIDSize]byte, error) {
+	var id [KeyIDSize]byte
+	raw, err := hex.DecodeString(c.CAKeyID)
+	if err != nil || len(raw) != KeyIDSize {
+		return id, errors.New("el identificador de la CA del certificado no es válido")
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+// Verify comprueba la firma del certificado con la clave pública de la CA.
+func (c *Certificate) Verify(caKey ed25519.PublicKey) bool {
+	return c.raw != nil && ed25519.Verify(caKey, c.raw, c.signature)
+}
+
+// ValidFrom devuelve el inicio del periodo de validez del certificado.
+func (c *Certificate) ValidFrom() time.Time {
+	return time.Unix(c.NotBefore, 0)
+}
+
+// ValidUntil devuelve el final del periodo de validez del certificado.
+func (c *Certificate) ValidUntil() time.Time {
+	return time.Unix(c.NotAfter, 0)
+}
+
+// EncodeCertificatePEM devuelve el certificado binario como archivo PEM.
+func EncodeCertificatePEM(cert []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: CertificatePEMType, Bytes: cert})
+}
+
+// DecodeCertificatePEM extrae el certificado binario de un archivo PEM.
+func DecodeCertificatePEM(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != CertificatePEMType {
+		return nil, errors.New("el archivo no es un certificado de GhostKnock")
+	}
+	return block.Bytes, nil
+}
+
+// AttachCertificate antepone el certificado al payload para un sobre con FlagCertificate.
+func AttachCertificate(cert, payload []byte) ([]byte, error) {
+	if len(cert) > 0xFFFF {
+		return nil, errors.New("el certificado es demasiado grande")
+	}
+	body := make([]byte, 0, 2+len(cert)+len(payload))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(cert)))
+	body = append(body, cert...)
+	return append(body, payload...), nil
+}
+
+// SplitCertificate separa el certificado y el payload del cuerpo de un sobre
+// con FlagCertificate.
+func SplitCertificate(body []byte) (cert, payload []byte, err error) {
+	if len(body) < 2 {
+		return nil, nil, ErrTruncated
+	}
+	certLen := int(binary.BigEndian.Uint16(body))
+	if len(body) <= 2+certLen {
+		return nil, nil, ErrTruncated
+	}
+	return body[2 : 2+certLen], body[2+certLen:], nil
+}
+
+// MatchesKey indica si el certificado es para la clave pública dada.
+func (c *Certificate) MatchesKey(publicKey ed25519.PublicKey) bool {
+	return bytes.Equal(c.PublicKey, publicKey)
+}
@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCertificateSignVerify(t *testing.T) {
+	caPublicKey, caKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notBefore := time.Unix(1_700_000_000, 0)
+	signed, err := NewCertificate("alice@ci", holderKey, []string{"open-ssh"}, []string{"10.0.0.0/8"}, notBefore, time.Hour).Sign(caKey)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// Lo que viaja en el sobre es el PEM decodificado; el ida y vuelta no debe alterarlo.
+	decoded, err := DecodeCertificatePEM(EncodeCertificatePEM(signed))
+	if err != nil || !bytes.Equal(decoded, signed) {
+		t.Fatalf("DecodeCertificatePEM(EncodeCertificatePEM()) = %v; no devuelve el certificado original", err)
+	}
+
+	cert, err := ParseCertificate(signed)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	if !cert.Verify(caPublicKey) {
+		t.Fatal("Verify() rechazó la CA que firmó el certificado")
+	}
+	if caKeyID, err := cert.CAKey(); err != nil || caKeyID != KeyIDFromPublicKey(caPublicKey) {
+		t.Errorf("CAKey() = %x, %v; se esperaba el key ID de la CA firmante", caKeyID, err)
+	}
+	if !cert.MatchesKey(holderKey) {
+		t.Error("MatchesKey() no reconoce la clave del titular")
+	}
+	if !cert.ValidFrom().Equal(notBefore) || !cert.ValidUntil().Equal(notBefore.Add(time.Hour)) {
+		t.Errorf("validez = [%v, %v], se esperaba [%v, %v]", cert.ValidFrom(), cert.ValidUntil(), notBefore, notBefore.Add(time.Hour))
+	}
+
+	otherCAPublicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Verify(otherCAPublicKey) {
+		t.Error("Verify() aceptó una CA distinta de la firmante")
+	}
+
+	// Ampliar las acciones o alterar la firma invalida el certificado.
+	tampered, err := ParseCertificate(bytes.Replace(signed, []byte("open-ssh"), []byte("open-rdp"), 1))
+	if err != nil {
+		t.Fatalf("ParseCertificate del certificado alterado: %v", err)
+	}
+	if tampered.Verify(caPublicKey) {
+		t.Error("Verify() aceptó un certificado con las acciones alteradas")
+	}
+	badSignature := append([]byte(nil), signed...)
+	badSignature[len(badSignature)-1] ^= 0xff
+	if forged, err := ParseCertificate(badSignature); err == nil && forged.Verify(caPublicKey) {
+		t.Error("Verify() aceptó una firma alterada")
+	}
+
+	// Un certificado recién creado aún no tiene firma.
+	if NewCertificate("alice@ci", holderKey, []string{"open-ssh"}, nil, time.Now(), time.Hour).Verify(caPublicKey) {
+		t.Error("Verify() aceptó un certificado sin firmar")
+	}
+}
+
+func TestCertificateSignRejectsInvalid(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]*Certificate{
+		"sin titular":   NewCertificate("", holderKey, []string{"open-ssh"}, nil, time.Now(), time.Hour),
+		"sin acciones":  NewCertificate("alice@ci", holderKey, nil, nil, time.Now(), time.Hour),
+		"clave corta":   NewCertificate("alice@ci", holderKey[:16], []string{"open-ssh"}, nil, time.Now(), time.Hour),
+		"validez vacía": NewCertificate("alice@ci", holderKey, []string{"open-ssh"}, nil, time.Now(), 0),
+	}
+	for name, cert := range invalid {
+		if _, err := cert.Sign(caKey); err == nil {
+			t.Errorf("%s: Sign() aceptó un certificado inválido", name)
+		}
+	}
+}
+
+func TestSplitCertificate(t *testing.T) {
+	cert := []byte("certificado")
+	payload := []byte("payload")
+
+	body, err := AttachCertificate(cert, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotCert, gotPayload, err := SplitCertificate(body)
+	if err != nil {
+		t.Fatalf("SplitCertificate: %v", err)
+	}
+	if !bytes.Equal(gotCert, cert) || !bytes.Equal(gotPayload, payload) {
+		t.Fatalf("SplitCertificate() = %q, %q; se esperaba %q, %q", gotCert, gotPayload, cert, payload)
+	}
+
+	// Sin payload tras el certificado el cuerpo está truncado.
+	for _, truncated := range [][]byte{nil, body[:1], body[:2+len(cert)]} {
+		if _, _, err := SplitCertificate(truncated); !errors.Is(err, ErrTruncated) {
+			t.Errorf("SplitCertificate(%q) error = %v, se esperaba %v", truncated, err, ErrTruncated)
+		}
+	}
+	if _, err := AttachCertificate(make([]byte, 0x10000), payload); err == nil {
+		t.Error("AttachCertificate() aceptó un certificado de más de 64 KiB")
+	}
+}
@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Formato binario de un knock (versión 1):
+//
+//	+--------+---------+-------+------------+----------------+-----------+
+//	| Magic  | Versión | Flags | KeyID      | Firma ed25519  | Cuerpo    |
+//	| 2 B    | 1 B     | 1 B   | 8 B        | 64 B           | variable  |
+//	+--------+---------+-------+------------+----------------+-----------+
+//
+// La firma cubre la cabecera (Magic, Versión, Flags y KeyID) seguida del cuerpo,
+// de modo que ningún campo del sobre puede alterarse sin invalidarla.
+const (
+	// Magic son los bytes iniciales que identifican un paquete de GhostKnock.
+	Magic = "GK"
+	// Version es la versión del formato de sobre que genera este código.
+	Version uint8 = 1
+	// KeyIDSize es el tamaño del identificador corto de la clave firmante.
+	KeyIDSize = 8
+	// HeaderSize es el tamaño de la parte de la cabecera cubierta por la firma.
+	HeaderSize = len(Magic) + 1 + 1 + KeyIDSize
+	// MaxMessageSize es el tamaño máximo de un knock completo, pensado para
+	// caber en un único datagrama UDP sin fragmentación.
+	MaxMessageSize = 1200
+)
+
+// knownFlags reúne los flags que entiende esta versión. Un sobre con cualquier
+// otro bit activo procede de un cliente más moderno o está manipulado.
+const knownFlags = FlagSealed | FlagAck | FlagOutput | FlagCertificate
+
+var (
+	// ErrBadMagic indica que el paquete no es de GhostKnock (ruido UDP ajeno).
+	ErrBadMagic = errors.New("el paquete no comienza con los bytes mágicos de GhostKnock")
+	// ErrUnsupportedVersion indica que el paquete usa una versión del protocolo desconocida.
+	ErrUnsupportedVersion = errors.New("versión del protocolo no soportada")
+	// ErrTruncated indica que el paquete es demasiado corto para contener un sobre válido.
+	ErrTruncated = errors.New("el paquete está truncado")
+	// ErrUnknownFlags indica que el sobre activa flags que esta versión no conoce.
+	ErrUnknownFlags = errors.New("el sobre contiene flags desconocidos")
+)
+
+// Envelope es el sobre binario versionado que transporta un payload firmado.
+type Envelope struct {
+	Version   uint8
+	Flags     uint8
+	KeyID     [KeyIDSize]byte
+	Signature []byte
+	Body      []byte
+}
+
+// KeyIDFromPublicKey calcula el identificador corto de una clave pública:
+// los primeros KeyIDSize bytes de su hash SHA-256.
+func KeyIDFromPublicKey(publicKey ed25519.PublicKey) [KeyIDSize]byte {
+	var id [KeyIDSize]byte
+	sum := sha256.Sum256(publicKey)
+	copy(id[:], sum[:KeyIDSize])
+	return id
+}
+
+// NewEnvelope crea un sobre de la versión actual para el cuerpo y los flags
+// dados, firmado con el firmante proporcionado. El firmante puede ser una
+// ed25519.PrivateKey o cualquier otro crypto.Signer con clave ed25519 (por
+// ejemplo, un ssh-agent), siempre que firme el mensaje completo sin hash previo.
+func NewEnvelope(body []byte, flags uint8, signer crypto.Signer) (*Envelope, error) {
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("la clave firmante debe ser ed25519, es %T", signer.Public())
+	}
+	env := &Envelope{
+		Version: Version,
+		Flags:   flags,
+		KeyID:   KeyIDFromPublicKey(publicKey),
+		Body:    body,
+	}
+
+	signature, err := signer.Sign(nil, env.SignedBytes(), crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("fallo al firmar el sobre: %w", err)
+	}
+	env.Signature = signature
+	return env, nil
+}
+
+// SignedBytes devuelve los bytes cubiertos por la firma: cabecera y cuerpo.
+func (e *Envelope) SignedBytes() []byte {
+	buf := make([]byte, 0, HeaderSize+len(e.Body))
+	buf = append(buf, Magic...)
+	buf = append(buf, e.Version, e.Flags)
+	buf = append(buf, e.KeyID[:]...)
+	return append(buf, e.Body...)
+}
+
+// Verify comprueba la firma del sobre con la clave pública dada.
+func (e *Envelope) Verify(publicKey ed25519.PublicKey) bool {
+	return ed25519.Verify(publicKey, e.SignedBytes(), e.Signature)
+}
+
+// Marshal serializa el sobre a su representación binaria para el envío.
+func (e *Envelope) Marshal() ([]byte, error) {
+	if len(e.Signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("la firma del sobre debe tener %d bytes, tiene %d", ed25519.SignatureSize, len(e.Signature))
+	}
+	if len(e.Body) == 0 {
+		return nil, errors.New("el cuerpo del sobre no puede estar vacío")
+	}
+
+	buf := make([]byte, 0, HeaderSize+ed25519.SignatureSize+len(e.Body))
+	buf = append(buf, Magic...)
+	buf = append(buf, e.Version, e.Flags)
+	buf = append(buf, e.KeyID[:]...)
+	buf = append(buf, e.Signature...)
+	buf = append(buf, e.Body...)
+
+	if len(buf) > MaxMessageSize {
+		return nil, fmt.Errorf("el knock ocupa %d bytes y supera el máximo de %d", len(buf), MaxMessageSize)
+	}
+	return buf, nil
+}
+
+// Unmarshal interpreta un paquete recibido como un sobre. Solo valida la
+// estructura (bytes mágicos, versión, flags y longitudes); no realiza ninguna
+// operación criptográfica, por lo que descartar ruido UDP ajeno es barato.
+func Unmarshal(data []byte) (*Envelope, error) {
+	if len(data) < len(Magic) || !bytes.Equal(data[:len(Magic)], []byte(Magic)) {
+		return nil, ErrBadMagic
+	}
+	if len(data) <= HeaderSize+ed25519.SignatureSize {
+		return nil, ErrTruncated
+	}
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("el paquete ocupa %d bytes y supera el máximo de %d", len(data), MaxMessageSize)
+	}
+
+	version := data[len(Magic)]
+	if version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	flags := data[len(Magic)+1]
+	if unknown := flags &^ knownFlags; unknown != 0 {
+		return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownFlags, unknown)
+	}
+
+	env := &Envelope{
+		Version: version,
+		Flags:   flags,
+	}
+	copy(env.KeyID[:], data[len(Magic)+2:HeaderSize])
+
+	// Copiamos firma y cuerpo para no retener el buffer del paquete capturado.
+	env.Signature = append([]byte(nil), data[HeaderSize:HeaderSize+ed25519.SignatureSize]...)
+	env.Body = append([]byte(nil), data[HeaderSize+ed25519.SignatureSize:]...)
+	return env, nil
+}
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// signedKnock devuelve un sobre firmado y serializado con el cuerpo y los flags dados.
+func signedKnock(t *testing.T, privateKey ed25519.PrivateKey, body []byte, flags uint8) []byte {
+	t.Helper()
+	env, err := NewEnvelope(body, flags, privateKey)
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return data
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		body  []byte
+		flags uint8
+	}{
+		{"en claro", []byte(`{"action_id":"open-ssh"}`), 0},
+		{"sellado", []byte("cuerpo cifrado"), FlagSealed},
+		{"con certificado y sellado", []byte("certificado + cuerpo cifrado"), FlagCertificate | FlagSealed},
+		{"tamaño máximo", bytes.Repeat([]byte("x"), MaxMessageSize-HeaderSize-ed25519.SignatureSize), 0},
+	}
+	for _, tt := range tests {
+		env, err := Unmarshal(signedKnock(t, privateKey, tt.body, tt.flags))
+		if err != nil {
+			t.Fatalf("%s: Unmarshal: %v", tt.name, err)
+		}
+		if env.Version != Version || env.Flags != tt.flags || env.KeyID != KeyIDFromPublicKey(publicKey) {
+			t.Errorf("%s: cabecera = (%d, %d, %x), se esperaba (%d, %d, %x)", tt.name, env.Version, env.Flags, env.KeyID, Version, tt.flags, KeyIDFromPublicKey(publicKey))
+		}
+		if !bytes.Equal(env.Body, tt.body) {
+			t.Errorf("%s: el cuerpo no coincide tras el ida y vuelta", tt.name)
+		}
+		if !env.Verify(publicKey) {
+			t.Errorf("%s: la firma no verifica tras el ida y vuelta", tt.name)
+		}
+	}
+}
+
+func TestNewEnvelopeRejectsNonEd25519Signer(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEnvelope([]byte("cuerpo"), 0, signer); err == nil {
+		t.Fatal("NewEnvelope aceptó un firmante ECDSA")
+	}
+}
+
+func TestEnvelopeMarshalTooLarge(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := NewEnvelope(bytes.Repeat([]byte("x"), MaxMessageSize), 0, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Marshal(); err == nil {
+		t.Fatal("Marshal aceptó un knock mayor que MaxMessageSize")
+	}
+}
+
+func TestEnvelopeSignatureCoversHeader(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := signedKnock(t, privateKey, []byte("cuerpo"), 0)
+
+	// Cambiar los flags o el key ID tras firmar debe invalidar la firma.
+	for _, offset := range []int{len(Magic) + 1, len(Magic) + 2} {
+		tampered := append([]byte(nil), data...)
+		tampered[offset] ^= 0x01
+		env, err := Unmarshal(tampered)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if env.Verify(publicKey) {
+			t.Errorf("la firma verificó un sobre con el byte %d alterado", offset)
+		}
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := signedKnock(t, privateKey, []byte("cuerpo"), 0)
+
+	withByte := func(offset int, value byte) []byte {
+		data := append([]byte(nil), valid...)
+		data[offset] = value
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"vacío", nil, ErrBadMagic},
+		{"bytes mágicos ajenos", withByte(0, 'X'), ErrBadMagic},
+		{"solo cabecera", valid[:HeaderSize], ErrTruncated},
+		{"firma cortada", valid[:HeaderSize+ed25519.SignatureSize/2], ErrTruncated},
+		{"sin cuerpo", valid[:HeaderSize+ed25519.SignatureSize], ErrTruncated},
+		{"versión desconocida", withByte(len(Magic), Version+1), ErrUnsupportedVersion},
+		// Un flag que esta versión no entiende podría cambiar el significado del cuerpo.
+		{"flag desconocido", withByte(len(Magic)+1, 1<<7), ErrUnknownFlags},
+		{"flag desconocido junto a uno conocido", withByte(len(Magic)+1, FlagSealed|1<<4), ErrUnknownFlags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unmarshal(tt.data); !errors.Is(err, tt.want) {
+				t.Fatalf("Unmarshal() error = %v, se esperaba %v", err, tt.want)
+			}
+		})
+	}
+
+	oversized := append(append([]byte(nil), valid...), make([]byte, MaxMessageSize)...)
+	if _, err := Unmarshal(oversized); err == nil {
+		t.Error("Unmarshal aceptó un paquete mayor que MaxMessageSize")
+	}
+}
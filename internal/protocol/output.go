@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// FlagOutput indica que el sobre es un fragmento de la salida de un comando.
+const FlagOutput uint8 = 1 << 2
+
+// Formato del cuerpo de un fragmento de salida:
+//
+//	[nonce del knock (16 B)][índice (2 B)][total (2 B)][fragmento del texto sellado]
+//
+// La salida se serializa como Output, se sella para la clave de respuesta del
+// cliente y el resultado se trocea en fragmentos que caben en un datagrama.
+// Cada fragmento va firmado por el servidor, de modo que el cliente descarta los
+// falsificados antes de reensamblar.
+const (
+	outputChunkHeaderSize = NonceSize + 2 + 2
+	// OutputChunkSize es el tamaño máximo de datos sellados por fragmento.
+	OutputChunkSize = MaxMessageSize - HeaderSize - ed25519.SignatureSize - outputChunkHeaderSize
+	// MaxOutputChunks limita el número de fragmentos de una misma salida.
+	MaxOutputChunks = 64
+)
+
+// Output es la salida de un comando que se devuelve al cliente. Stdout y Stderr
+// viajan en Base64, de modo que el tamaño sellado no depende del contenido.
+type Output struct {
+	Stdout    []byte `json:"stdout,omitempty"`
+	Stderr    []byte `json:"stderr,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// OutputChunk es un fragmento de salida ya verificado.
+type OutputChunk struct {
+	Index int
+	Total int
+	Data  []byte
+}
+
+// SealOutput cifra la salida para la clave de respuesta del cliente y devuelve
+// los fragmentos firmados por el servidor, listos para enviar.
+func SealOutput(out Output, requestNonce string, replyKey *ecdh.PublicKey, serverKey ed25519.PrivateKey) ([][]byte, error) {
+	nonce, err := hex.DecodeString(requestNonce)
+	if err != nil || len(nonce) != NonceSize {
+		return nil, errors.New("el nonce del knock no es válido")
+	}
+
+	plaintext, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := Seal(replyKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	total := (len(sealed) + OutputChunkSize - 1) / OutputChunkSize
+	if total > MaxOutputChunks {
+		return nil, fmt.Errorf("la salida necesita %d fragmentos y supera el máximo de %d", total, MaxOutputChunks)
+	}
+
+	packets := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		data := sealed[i*OutputChunkSize : min((i+1)*OutputChunkSize, len(sealed))]
+
+		body := make([]byte, 0, outputChunkHeaderSize+len(data))
+		body = append(body, nonce...)
+		body = binary.BigEndian.AppendUint16(body, uint16(i))
+		body = binary.BigEndian.AppendUint16(body, uint16(total))
+		body = append(body, data...)
+
+		env, err := NewEnvelope(body, FlagOutput, serverKey)
+		if err != nil {
+			return nil, err
+		}
+		packet, err := env.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
+
+// ParseOutputChunk verifica un fragmento de salida con la clave pública del
+// servidor y comprueba que responde al knock con el nonce indicado.
+func ParseOutputChunk(data []byte, serverKey ed25519.PublicKey, requestNonce string) (*OutputChunk, error) {
+	env, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.Flags&FlagOutput == 0 {
+		return nil, errors.New("el paquete no es un fragmento de salida")
+	}
+	if env.KeyID != KeyIDFromPublicKey(serverKey) || !env.Verify(serverKey) {
+		return nil, errors.New("la firma del fragmento de salida no corresponde a la clave del servidor")
+	}
+	if len(env.Body) <= outputChunkHeaderSize {
+		return nil, ErrTruncated
+	}
+
+	nonce, err := hex.DecodeString(requestNonce)
+	if err != nil || !bytes.Equal(env.Body[:NonceSize], nonce) {
+		return nil, errors.New("el fragmento de salida corresponde a otro knock")
+	}
+
+	chunk := &OutputChunk{
+		Index: int(binary.BigEndian.Uint16(env.Body[NonceSize:])),
+		Total: int(binary.BigEndian.Uint16(env.Body[NonceSize+2:])),
+		Data:  env.Body[outputChunkHeaderSize:],
+	}
+	if chunk.Total == 0 || chunk.Total > MaxOutputChunks || chunk.Index >= chunk.Total {
+		return nil, errors.New("el fragmento de salida tiene un índice inválido")
+	}
+	return chunk, nil
+}
+
+// OpenOutput reensambla los fragmentos (ordenados por índice) y descifra la
+// salida con la clave privada de respuesta del cliente.
+func OpenOutput(chunks [][]byte, replyKey *ecdh.PrivateKey) (*Output, error) {
+	plaintext, err := Open(replyKey, bytes.Join(chunks, nil))
+	if err != nil {
+		return nil, err
+	}
+	var out Output
+	if err := json.Unmarshal(plaintext, &out); err != nil {
+		return nil, fmt.Errorf("fallo al deserializar la salida: %w", err)
+	}
+	return &out, nil
+}
@@ -3,25 +3,58 @@
 package protocol
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt" // <<-- ESTA LÍNEA ESTABA AUSENTE. AHORA ESTÁ AQUÍ.
 	"time"
 )
 
+// NonceSize es el número de bytes aleatorios que identifican de forma única a cada payload.
+const NonceSize = 16
+
+// Verbos de control. Un payload sin verbo solicita ejecutar la acción; los
+// verbos de control actúan sobre la reversión pendiente de una ejecución previa
+// del mismo usuario, acción e IP de origen.
+const (
+	VerbExecute   = ""
+	VerbRevertNow = "revert-now"
+	VerbExtend    = "extend"
+	// VerbApprove aprueba la solicitud pendiente RequestID de una acción que
+	// requiere aprobaciones de varios usuarios.
+	VerbApprove = "approve"
+)
+
 // Payload es la estructura de datos que el cliente envía al servidor.
 // Contiene la información necesaria para que el servidor verifique la solicitud
 // y decida si ejecuta una acción.
 type Payload struct {
 	Timestamp int64             `json:"timestamp"`
+	Nonce     string            `json:"nonce"`
 	ActionID  string            `json:"action_id"`
 	Params    map[string]string `json:"params,omitempty"`
+	Verb      string            `json:"verb,omitempty"`
+	// ExtendSeconds indica, con el verbo VerbExtend, dentro de cuántos segundos
+	// (contados desde la recepción) debe ejecutarse la reversión.
+	ExtendSeconds int `json:"extend_seconds,omitempty"`
+	// ReplyKey es una clave pública X25519 efímera (Base64) del cliente. Si la
+	// acción devuelve su salida, el servidor la cifra para esta clave.
+	ReplyKey string `json:"reply_key,omitempty"`
+	// RequestID identifica, con el verbo VerbApprove, la solicitud que se
+	// aprueba: es el nonce del knock original.
+	RequestID string `json:"request_id,omitempty"`
 }
 
-// NewPayload crea una nueva instancia de Payload con la marca de tiempo actual.
+// NewPayload crea una nueva instancia de Payload con la marca de tiempo actual
+// y un nonce aleatorio que el servidor usa para aceptar cada knock una sola vez.
 func NewPayload(actionID string) *Payload {
+	nonce := make([]byte, NonceSize)
+	// crypto/rand.Read nunca devuelve error; si el sistema no puede proveer entropía, el proceso aborta.
+	rand.Read(nonce)
 	return &Payload{
 		Timestamp: time.Now().UnixNano(),
+		Nonce:     hex.EncodeToString(nonce),
 		ActionID:  actionID,
 		Params:    make(map[string]string),
 	}
@@ -32,6 +65,12 @@ func (p *Payload) Serialize() ([]byte, error) {
 	if p.ActionID == "" {
 		return nil, errors.New("ActionID no puede estar vacío")
 	}
+	if p.Nonce == "" {
+		return nil, errors.New("Nonce no puede estar vacío")
+	}
+	if err := p.validateVerb(); err != nil {
+		return nil, err
+	}
 	return json.Marshal(p)
 }
 
@@ -47,5 +86,38 @@ func DeserializePayload(data []byte) (*Payload, error) {
 	if p.ActionID == "" {
 		return nil, errors.New("el payload deserializado no contiene ActionID")
 	}
+	if nonce, err := hex.DecodeString(p.Nonce); err != nil || len(nonce) != NonceSize {
+		return nil, fmt.Errorf("el payload deserializado no contiene un nonce válido de %d bytes", NonceSize)
+	}
+	if err := p.validateVerb(); err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
+
+// validateVerb comprueba que el verbo de control sea conocido y coherente con sus argumentos.
+func (p *Payload) validateVerb() error {
+	if p.Verb != VerbApprove && p.RequestID != "" {
+		return fmt.Errorf("request_id solo es válido con el verbo '%s'", VerbApprove)
+	}
+	switch p.Verb {
+	case VerbExecute, VerbRevertNow:
+		if p.ExtendSeconds != 0 {
+			return fmt.Errorf("extend_seconds solo es válido con el verbo '%s'", VerbExtend)
+		}
+	case VerbExtend:
+		if p.ExtendSeconds <= 0 {
+			return fmt.Errorf("el verbo '%s' requiere un extend_seconds positivo", VerbExtend)
+		}
+	case VerbApprove:
+		if p.ExtendSeconds != 0 {
+			return fmt.Errorf("extend_seconds solo es válido con el verbo '%s'", VerbExtend)
+		}
+		if id, err := hex.DecodeString(p.RequestID); err != nil || len(id) != NonceSize {
+			return fmt.Errorf("el verbo '%s' requiere un request_id válido de %d bytes", VerbApprove, NonceSize)
+		}
+	default:
+		return fmt.Errorf("verbo de control desconocido: '%s'", p.Verb)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// FlagSealed indica que el cuerpo del sobre está cifrado para la clave X25519 del servidor.
+const FlagSealed uint8 = 1 << 0
+
+// Formato de un cuerpo sellado:
+//
+//	[clave pública X25519 efímera (32 B)][nonce AES-GCM (12 B)][texto cifrado + etiqueta (16 B)]
+//
+// La clave simétrica se deriva con HKDF-SHA256 del secreto X25519 compartido,
+// usando como sal la clave efímera seguida de la del destinatario. La firma
+// ed25519 del sobre se calcula sobre el cuerpo ya sellado, así que el servidor
+// autentica el paquete antes de gastar CPU en descifrarlo.
+const (
+	sealKeySize   = 32
+	sealNonceSize = 12
+	sealInfo      = "ghostknock sealed payload v1"
+)
+
+// ErrSealedTooShort indica que el cuerpo sellado no tiene el tamaño mínimo.
+var ErrSealedTooShort = errors.New("el cuerpo sellado es demasiado corto")
+
+// ParseSealingPublicKey decodifica una clave pública X25519 en Base64.
+func ParseSealingPublicKey(b64 string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("la clave de sellado no es un Base64 válido: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("la clave de sellado no es una clave pública X25519 válida: %w", err)
+	}
+	return key, nil
+}
+
+// Seal cifra el texto plano para el destinatario usando una clave efímera.
+func Seal(recipient *ecdh.PublicKey, plaintext []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo generar la clave efímera: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("fallo en el acuerdo de claves X25519: %w", err)
+	}
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	aead, err := newSealAEAD(shared, ephemeralPub, recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, sealKeySize+sealNonceSize+len(plaintext)+aead.Overhead())
+	out = append(out, ephemeralPub...)
+	nonce := make([]byte, sealNonceSize)
+	rand.Read(nonce)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, nil), nil
+}
+
+// Open descifra un cuerpo producido por Seal con la clave privada del destinatario.
+func Open(recipient *ecdh.PrivateKey, sealed []byte) ([]byte, error) {
+	if len(sealed) < sealKeySize+sealNonceSize {
+		return nil, ErrSealedTooShort
+	}
+
+	ephemeralPub, err := ecdh.X25519().NewPublicKey(sealed[:sealKeySize])
+	if err != nil {
+		return nil, fmt.Errorf("clave efímera inválida: %w", err)
+	}
+	shared, err := recipient.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("fallo en el acuerdo de claves X25519: %w", err)
+	}
+
+	aead, err := newSealAEAD(shared, ephemeralPub.Bytes(), recipient.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := sealed[sealKeySize : sealKeySize+sealNonceSize]
+	plaintext, err := aead.Open(nil, nonce, sealed[sealKeySize+sealNonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo descifrar el cuerpo sellado: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newSealAEAD(shared, ephemeralPub, recipientPub []byte) (cipher.AEAD, error) {
+	salt := make([]byte, 0, len(ephemeralPub)+len(recipientPub))
+	salt = append(salt, ephemeralPub...)
+	salt = append(salt, recipientPub...)
+
+	key, err := hkdf.Key(sha256.New, shared, salt, sealInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("fallo al derivar la clave simétrica: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("fallo al inicializar AES: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
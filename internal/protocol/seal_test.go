@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newSealingKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	server := newSealingKey(t)
+	plaintext := []byte(`{"action_id":"open-ssh","nonce":"abc"}`)
+
+	sealed, err := Seal(server.PublicKey(), plaintext)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("el cuerpo sellado contiene el texto plano")
+	}
+	got, err := Open(server, sealed)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("Open() = %q, se esperaba %q", got, plaintext)
+	}
+
+	// Cada sellado usa una clave efímera nueva, así que dos knocks con el mismo
+	// payload no se pueden relacionar por su cuerpo cifrado.
+	again, err := Seal(server.PublicKey(), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again[:sealKeySize], sealed[:sealKeySize]) {
+		t.Error("dos sellados comparten la clave efímera")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	server := newSealingKey(t)
+	sealed, err := Seal(server.PublicKey(), []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(offset int) []byte {
+		data := append([]byte(nil), sealed...)
+		data[offset] ^= 0xff
+		return data
+	}
+
+	if _, err := Open(newSealingKey(t), sealed); err == nil {
+		t.Error("Open() descifró con la clave de otro servidor")
+	}
+	if _, err := Open(server, flip(0)); err == nil {
+		t.Error("Open() aceptó una clave efímera alterada")
+	}
+	if _, err := Open(server, flip(sealKeySize)); err == nil {
+		t.Error("Open() aceptó un nonce alterado")
+	}
+	if _, err := Open(server, flip(len(sealed)-1)); err == nil {
+		t.Error("Open() aceptó un texto cifrado alterado")
+	}
+	if _, err := Open(server, sealed[:sealKeySize+sealNonceSize]); err == nil {
+		t.Error("Open() aceptó un cuerpo sin etiqueta de autenticación")
+	}
+	if _, err := Open(server, sealed[:sealKeySize]); !errors.Is(err, ErrSealedTooShort) {
+		t.Errorf("Open() de un cuerpo corto: error = %v, se esperaba %v", err, ErrSealedTooShort)
+	}
+}
+
+func TestParseSealingPublicKey(t *testing.T) {
+	key := newSealingKey(t)
+
+	got, err := ParseSealingPublicKey(base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatalf("ParseSealingPublicKey: %v", err)
+	}
+	if !got.Equal(key.PublicKey()) {
+		t.Error("la clave decodificada no coincide")
+	}
+
+	for _, input := range []string{"no es base64!", base64.StdEncoding.EncodeToString([]byte("corta"))} {
+		if _, err := ParseSealingPublicKey(input); err == nil {
+			t.Errorf("ParseSealingPublicKey(%q) no devolvió error", input)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// El paquete revocation mantiene la lista de claves revocadas de ghostknockd.
+// La lista vive en un archivo propio, separado de config.yaml, que el demonio
+// vigila y recarga en cuanto cambia, sin reiniciar ni tocar las acciones.
+package revocation
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/your-org/ghostknock/internal/config"
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+// PollInterval es la frecuencia con la que se comprueba si el archivo cambió.
+const PollInterval = 5 * time.Second
+
+// serialPrefix antecede al número de serie de un certificado revocado, que por
+// lo demás se confundiría con un key ID.
+const serialPrefix = "serial:"
+
+// List es un conjunto inmutable de claves y certificados revocados. Cada línea
+// del archivo es una clave pública (Base64 o 'ssh-ed25519 AAAA...'), una huella
+// SHA256 de OpenSSH ('SHA256:...'), un key ID en hexadecimal (16 caracteres,
+// como en el campo 'key_id' de los logs) o el número de serie de un certificado
+// ('serial:' seguido del 'certificate_serial' de los logs). Las líneas vacías y
+// las que empiezan por '#' se ignoran.
+type List struct {
+	keys         map[string]struct{}
+	keyIDs       map[[protocol.KeyIDSize]byte]struct{}
+	fingerprints map[string]struct{}
+	serials      map[string]struct{}
+}
+
+// Parse interpreta el contenido de un archivo de revocación.
+func Parse(data []byte) (*List, error) {
+	list := &List{
+		keys:         make(map[string]struct{}),
+		keyIDs:       make(map[[protocol.KeyIDSize]byte]struct{}),
+		fingerprints: make(map[string]struct{}),
+		serials:      make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := list.add(line); err != nil {
+			return nil, fmt.Errorf("línea %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *List) add(entry string) error {
+	if serial, ok := strings.CutPrefix(entry, serialPrefix); ok {
+		raw, err := hex.DecodeString(serial)
+		if err != nil || len(raw) == 0 {
+			return fmt.Errorf("número de serie de certificado inválido: '%s'", serial)
+		}
+		l.serials[hex.EncodeToString(raw)] = struct{}{}
+		return nil
+	}
+	if strings.HasPrefix(entry, "SHA256:") {
+		l.fingerprints[entry] = struct{}{}
+		return nil
+	}
+	if len(entry) == hex.EncodedLen(protocol.KeyIDSize) {
+		if raw, err := hex.DecodeString(entry); err == nil {
+			var keyID [protocol.KeyIDSize]byte
+			copy(keyID[:], raw)
+			l.keyIDs[keyID] = struct{}{}
+			return nil
+		}
+	}
+	publicKey, err := config.ParsePublicKey(entry)
+	if err != nil {
+		return fmt.Errorf("no es una clave pública, una huella SHA256 ni un key ID: %w", err)
+	}
+	l.keys[string(publicKey)] = struct{}{}
+	return nil
+}
+
+// Len devuelve el número de entradas de la lista.
+func (l *List) Len() int {
+	return len(l.keys) + len(l.keyIDs) + len(l.fingerprints) + len(l.serials)
+}
+
+// Contains indica si la clave pública está revocada.
+func (l *List) Contains(publicKey ed25519.PublicKey) bool {
+	if _, ok := l.keys[string(publicKey)]; ok {
+		return true
+	}
+	if _, ok := l.keyIDs[protocol.KeyIDFromPublicKey(publicKey)]; ok {
+		return true
+	}
+	if len(l.fingerprints) > 0 {
+		if sshKey, err := ssh.NewPublicKey(publicKey); err == nil {
+			_, ok := l.fingerprints[ssh.FingerprintSHA256(sshKey)]
+			return ok
+		}
+	}
+	return false
+}
+
+// ContainsCertificate indica si el certificado está revocado: por su número de
+// serie, por la clave que certifica o por la clave de la CA que lo firmó.
+func (l *List) ContainsCertificate(cert *protocol.Certificate, caKey ed25519.PublicKey) bool {
+	if _, ok := l.serials[strings.ToLower(cert.Serial)]; ok {
+		return true
+	}
+	return l.Contains(ed25519.PublicKey(cert.PublicKey)) || l.Contains(caKey)
+}
+
+// Watcher mantiene la lista cargada desde un archivo y la recarga cuando cambia
+// su fecha de modificación o su tamaño. Un Watcher nil no revoca ninguna clave.
+type Watcher struct {
+	path string
+
+	mu   sync.RWMutex
+	list *List
+
+	// Estado de la última comprobación, usado solo por la gorrutina de Run.
+	modTime    time.Time
+	size       int64
+	statFailed bool
+}
+
+// NewWatcher carga el archivo de revocación. Un error en la carga inicial se
+// devuelve para que el demonio no arranque sin la lista que se le ha indicado.
+func NewWatcher(path string) (*Watcher, error) {
+	w := &Watcher{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.load(info); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Path devuelve la ruta del archivo vigilado.
+func (w *Watcher) Path() string {
+	return w.path
+}
+
+// Contains indica si la clave pública está en la lista vigente.
+func (w *Watcher) Contains(publicKey ed25519.PublicKey) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.list.Contains(publicKey)
+}
+
+// ContainsCertificate indica si el certificado está revocado en la lista vigente.
+func (w *Watcher) ContainsCertificate(cert *protocol.Certificate, caKey ed25519.PublicKey) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.list.ContainsCertificate(cert, caKey)
+}
+
+// Run comprueba el archivo cada PollInterval hasta que se cierre stop. Si el
+// archivo nuevo no es válido o desaparece, se conserva la lista anterior.
+func (w *Watcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		if !w.statFailed {
+			slog.Error("No se pudo comprobar el archivo de revocación, se mantiene la lista anterior", "path", w.path, "error", err)
+			w.statFailed = true
+		}
+		return
+	}
+	w.statFailed = false
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return
+	}
+	if err := w.load(info); err != nil {
+		slog.Error("Archivo de revocación inválido, se mantiene la lista anterior", "path", w.path, "error", err)
+	}
+}
+
+// load lee y aplica el archivo. La fecha y el tamaño se recuerdan aunque falle,
+// de modo que un archivo inválido se vuelve a leer solo cuando se modifique.
+func (w *Watcher) load(info os.FileInfo) error {
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	list, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("archivo de revocación '%s': %w", w.path, err)
+	}
+
+	w.mu.Lock()
+	w.list = list
+	w.mu.Unlock()
+
+	slog.Info("Lista de revocación cargada", "path", w.path, "entries", list.Len())
+	return nil
+}
@@ -0,0 +1,48 @@
+package revocation
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/ghostknock/internal/protocol"
+)
+
+func TestParseCertificateSerials(t *testing.T) {
+	holderKey, _, _ := ed25519.GenerateKey(nil)
+	caKey, _, _ := ed25519.GenerateKey(nil)
+	cert := protocol.NewCertificate("ci@build", holderKey, []string{"open-ssh"}, nil, time.Now(), time.Hour)
+
+	list, err := Parse([]byte("# revocados\nserial:" + strings.ToUpper(cert.Serial) + "\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !list.ContainsCertificate(cert, caKey) {
+		t.Error("no se reconoce el número de serie revocado (en mayúsculas)")
+	}
+	// El número de serie no revoca la clave fuera del certificado.
+	if list.Contains(holderKey) {
+		t.Error("un número de serie revocó la clave pública del titular")
+	}
+	other := protocol.NewCertificate("ci@build", holderKey, []string{"open-ssh"}, nil, time.Now(), time.Hour)
+	if list.ContainsCertificate(other, caKey) {
+		t.Error("se revocó un certificado con otro número de serie")
+	}
+
+	// Revocar la CA revoca todos sus certificados.
+	list, err = Parse([]byte(base64.StdEncoding.EncodeToString(caKey)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !list.ContainsCertificate(other, caKey) {
+		t.Error("revocar la CA no revocó sus certificados")
+	}
+
+	for _, entry := range []string{"serial:", "serial:xyz"} {
+		if _, err := Parse([]byte(entry)); err == nil {
+			t.Errorf("Parse(%q) aceptó un número de serie inválido", entry)
+		}
+	}
+}